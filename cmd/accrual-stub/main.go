@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/wurt83ow/gophermart/internal/accrualstub"
+	"github.com/wurt83ow/gophermart/internal/logger"
+	"go.uber.org/zap"
+)
+
+func main() {
+	addr := flag.String("a", ":8082", "address and port to run accrual stub")
+	cfgPath := flag.String("c", "", "path to the JSON config of the stub")
+	logLevel := flag.String("l", "info", "log level")
+	flag.Parse()
+
+	nLogger, err := logger.NewLogger(*logLevel)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// without a config every order is processed with zero accrual
+	cfg := accrualstub.Config{
+		DefaultGoods: []accrualstub.Good{{Description: "default", Price: 0}},
+	}
+
+	if *cfgPath != "" {
+		cfg, err = accrualstub.LoadConfig(*cfgPath)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	stub := accrualstub.NewServer(cfg, nLogger)
+
+	nLogger.Info("Running accrual stub", zap.String("address", *addr))
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           stub.Route(),
+		ReadHeaderTimeout: 3 * time.Second,
+	}
+
+	err = srv.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatalln(err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/signal"
 	"runtime/pprof"
+	"syscall"
 
 	"github.com/wurt83ow/gophermart/internal/app"
 )
@@ -35,7 +36,8 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	// docker stop sends SIGTERM, the accrual pipeline is drained on both
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
 	server := app.NewServer(ctx)
 
@@ -0,0 +1,280 @@
+package accrualstub
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/wurt83ow/gophermart/internal/models"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type Log interface {
+	Info(string, ...zapcore.Field)
+}
+
+// Rule sets the reward for goods whose description contains Match.
+// RewardType is "%" for a percentage of the price or "pt" for fixed points.
+type Rule struct {
+	Match      string       `json:"match"`
+	Reward     models.Money `json:"reward"`
+	RewardType string       `json:"reward_type"`
+}
+
+type Good struct {
+	Description string       `json:"description"`
+	Price       models.Money `json:"price"`
+}
+
+type RequestOrder struct {
+	Order string `json:"order"`
+	Goods []Good `json:"goods"`
+}
+
+// Config describes the behaviour of the stub.
+type Config struct {
+	// reward rules, the first matching rule is applied to a good
+	Rules []Rule `json:"rules"`
+	// goods assumed for orders not registered through POST /api/orders,
+	// when empty such orders are answered with 204
+	DefaultGoods []Good `json:"default_goods"`
+	// artificial latency of every order request
+	LatencyMS int `json:"latency_ms"`
+	// every BurstEvery-th request starts a burst of BurstLength 429 answers
+	BurstEvery  int `json:"burst_every"`
+	BurstLength int `json:"burst_length"`
+	RetryAfter  int `json:"retry_after"`
+	// orders which are always answered with 204 or INVALID
+	Unregistered []string `json:"unregistered"`
+	Invalid      []string `json:"invalid"`
+	// share of other orders (0-100) answered with 204 or INVALID
+	UnregisteredPercent int `json:"unregistered_percent"`
+	InvalidPercent      int `json:"invalid_percent"`
+	// polls an order spends in REGISTERED and PROCESSING before the final status
+	ProcessingPolls int `json:"processing_polls"`
+}
+
+// LoadConfig reads the stub config from a JSON file.
+func LoadConfig(path string) (Config, error) {
+	cfg := Config{}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read stub config: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse stub config: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// Server is an in-process stand-in for the accrual system.
+type Server struct {
+	mx       sync.Mutex
+	cfg      Config
+	orders   map[string][]Good
+	polls    map[string]int
+	requests int
+	log      Log
+}
+
+func NewServer(cfg Config, log Log) *Server {
+	return &Server{
+		cfg:    cfg,
+		orders: make(map[string][]Good),
+		polls:  make(map[string]int),
+		log:    log,
+	}
+}
+
+func (s *Server) Route() *chi.Mux {
+	r := chi.NewRouter()
+
+	r.Get("/api/orders/{number}", s.GetOrder)
+	r.Post("/api/orders", s.RegisterOrder)
+	r.Post("/api/goods", s.AddRule)
+
+	return r
+}
+
+func (s *Server) GetOrder(w http.ResponseWriter, r *http.Request) {
+	number := chi.URLParam(r, "number")
+
+	if s.cfg.LatencyMS > 0 {
+		select {
+		case <-time.After(time.Duration(s.cfg.LatencyMS) * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if s.throttled() {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Retry-After", strconv.Itoa(s.cfg.RetryAfter))
+		w.WriteHeader(http.StatusTooManyRequests) // 429
+		fmt.Fprintf(w, "Too many requests, %d of every %d requests are throttled",
+			s.cfg.BurstLength, s.cfg.BurstEvery)
+		s.log.Info("stub throttles request, status 429: ", zap.String("order", number))
+		return
+	}
+
+	resp, ok := s.orderState(number)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent) // 204
+		s.log.Info("order is not registered, status 204: ", zap.String("order", number))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(resp); err != nil {
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		s.log.Info("Internal Server Error: ", zap.Error(err))
+	}
+}
+
+func (s *Server) RegisterOrder(w http.ResponseWriter, r *http.Request) {
+	req := RequestOrder{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Order == "" {
+		w.WriteHeader(http.StatusBadRequest) // 400
+		return
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if _, exists := s.orders[req.Order]; exists {
+		w.WriteHeader(http.StatusConflict) // 409
+		return
+	}
+
+	s.orders[req.Order] = req.Goods
+	w.WriteHeader(http.StatusAccepted) // 202
+}
+
+func (s *Server) AddRule(w http.ResponseWriter, r *http.Request) {
+	rule := Rule{}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil || rule.Match == "" ||
+		(rule.RewardType != "%" && rule.RewardType != "pt") {
+		w.WriteHeader(http.StatusBadRequest) // 400
+		return
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	for _, v := range s.cfg.Rules {
+		if v.Match == rule.Match {
+			w.WriteHeader(http.StatusConflict) // 409
+			return
+		}
+	}
+
+	s.cfg.Rules = append(s.cfg.Rules, rule)
+	w.WriteHeader(http.StatusOK) // 200
+}
+
+// throttled counts the request and reports whether it falls into a 429 burst.
+func (s *Server) throttled() bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.requests++
+
+	if s.cfg.BurstEvery <= 0 || s.cfg.BurstLength <= 0 {
+		return false
+	}
+
+	return (s.requests-1)%s.cfg.BurstEvery >= s.cfg.BurstEvery-s.cfg.BurstLength
+}
+
+// orderState returns the answer for the next poll of the order,
+// false means the order is not registered.
+func (s *Server) orderState(number string) (models.ExtRespOrder, bool) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	goods, registered := s.orders[number]
+	if !registered {
+		if contains(s.cfg.Unregistered, number) || percentOf(number, "unregistered") < s.cfg.UnregisteredPercent ||
+			len(s.cfg.DefaultGoods) == 0 {
+			return models.ExtRespOrder{}, false
+		}
+
+		goods = s.cfg.DefaultGoods
+	}
+
+	s.polls[number]++
+	poll := s.polls[number]
+
+	resp := models.ExtRespOrder{Order: number}
+
+	switch {
+	case poll <= s.cfg.ProcessingPolls/2:
+		resp.Status = "REGISTERED"
+	case poll <= s.cfg.ProcessingPolls:
+		resp.Status = "PROCESSING"
+	case contains(s.cfg.Invalid, number) || percentOf(number, "invalid") < s.cfg.InvalidPercent:
+		resp.Status = "INVALID"
+	default:
+		resp.Status = "PROCESSED"
+		resp.Accrual = s.reward(goods)
+	}
+
+	return resp, true
+}
+
+// reward calculates the accrual of the goods by the first matching rules.
+func (s *Server) reward(goods []Good) models.Money {
+	var accrual models.Money
+
+	for _, g := range goods {
+		for _, rule := range s.cfg.Rules {
+			if !strings.Contains(g.Description, rule.Match) {
+				continue
+			}
+
+			if rule.RewardType == "pt" {
+				accrual += rule.Reward
+			} else {
+				// both the price and the percentage are in hundredths,
+				// the result is rounded half up
+				accrual += (g.Price*rule.Reward + 5000) / 10000
+			}
+
+			break
+		}
+	}
+
+	return accrual
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// percentOf deterministically maps the order number to 0-99,
+// so the same order always gets the same outcome.
+func percentOf(number string, salt string) int {
+	h := fnv.New32a()
+	h.Write([]byte(salt + number))
+
+	return int(h.Sum32() % 100)
+}
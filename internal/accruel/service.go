@@ -2,19 +2,30 @@ package accruel
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"sync"
 	"time"
 
+	"github.com/wurt83ow/gophermart/internal/breaker"
+	"github.com/wurt83ow/gophermart/internal/controllers"
 	"github.com/wurt83ow/gophermart/internal/models"
+	"github.com/wurt83ow/gophermart/internal/storage"
 	"github.com/wurt83ow/gophermart/internal/workerpool"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+// accrualTask is the type of pool tasks requesting the accrual system.
+const accrualTask = "accrual"
+
+// accrualTaskAttempts is the number of attempts of an accrual task
+// before it is moved to the dead letters.
+const accrualTaskAttempts = 3
+
 type External interface {
-	GetExtOrderAccruel(string) (models.ExtRespOrder, error)
+	GetExtOrderAccruel(context.Context, string) (models.ExtRespOrder, error)
 }
 
 type Log interface {
@@ -22,29 +33,43 @@ type Log interface {
 }
 
 type Storage interface {
-	GetOpenOrders() ([]string, error)
-	UpdateOrderStatus([]models.ExtRespOrder) error
+	GetOpenOrders(models.PollSchedule) ([]models.DataOrder, error)
+	UpdateOrderStatus([]models.ExtRespOrder) ([]string, error)
 	InsertAccruel(map[string]models.ExtRespOrder) error
+	SetOrderPushed(string) error
+	HasOrder(string) (bool, error)
 }
 
 type Pool interface {
 	// NewTask(f func(interface{}) error, data interface{}) *workerpool.Task
-	AddTask(task *workerpool.Task)
+	AddTask(task *workerpool.Task) error
+	SetRetryPolicy(string, workerpool.RetryPolicy)
+	Drain(context.Context) error
+	Stop()
 }
 
 type AccrualService struct {
-	results      chan interface{}
-	wg           sync.WaitGroup
-	cancelFunc   context.CancelFunc
-	external     External
-	pool         Pool
-	storage      Storage
-	log          Log
-	taskInterval int
+	results             chan interface{}
+	drained             chan struct{}
+	wg                  sync.WaitGroup
+	stopOnce            sync.Once
+	cancelFunc          context.CancelFunc
+	external            External
+	pool                Pool
+	storage             Storage
+	log                 Log
+	taskInterval        int
+	unregisteredTimeout time.Duration
+	maxPollBackoff      time.Duration
+	orderLease          time.Duration
+	pushWindow          time.Duration
+	taskTimeout         time.Duration
 }
 
 func NewAccrualService(external External, pool Pool, storage Storage,
-	log Log, taskInterval func() string,
+	log Log, taskInterval func() string, unregisteredTimeout func() string,
+	maxPollBackoff func() string, orderLeaseTimeout func() string,
+	pushWindow func() string, taskTimeout func() string,
 ) *AccrualService {
 	taskInt, err := strconv.Atoi(taskInterval())
 	if err != nil {
@@ -53,15 +78,61 @@ func NewAccrualService(external External, pool Pool, storage Storage,
 		taskInt = 3000
 	}
 
+	timeout, err := strconv.Atoi(unregisteredTimeout())
+	if err != nil {
+		log.Info("cannot convert unregistered order timeout option: ", zap.Error(err))
+
+		timeout = 24
+	}
+
+	maxBackoff, err := strconv.Atoi(maxPollBackoff())
+	if err != nil {
+		log.Info("cannot convert max poll backoff option: ", zap.Error(err))
+
+		maxBackoff = 3600000
+	}
+
+	lease, err := strconv.Atoi(orderLeaseTimeout())
+	if err != nil {
+		log.Info("cannot convert order lease timeout option: ", zap.Error(err))
+
+		lease = 60000
+	}
+
+	window, err := strconv.Atoi(pushWindow())
+	if err != nil {
+		log.Info("cannot convert push fallback window option: ", zap.Error(err))
+
+		window = 0
+	}
+
+	timeoutTask, err := strconv.Atoi(taskTimeout())
+	if err != nil {
+		log.Info("cannot convert task timeout option: ", zap.Error(err))
+
+		timeoutTask = 10000
+	}
+
+	pool.SetRetryPolicy(accrualTask, workerpool.RetryPolicy{
+		MaxAttempts: accrualTaskAttempts,
+		Backoff:     time.Duration(taskInt) * time.Millisecond,
+	})
+
 	return &AccrualService{
-		results:      make(chan interface{}),
-		wg:           sync.WaitGroup{},
-		cancelFunc:   nil,
-		external:     external,
-		pool:         pool,
-		storage:      storage,
-		log:          log,
-		taskInterval: taskInt,
+		results:             make(chan interface{}),
+		drained:             make(chan struct{}),
+		wg:                  sync.WaitGroup{},
+		cancelFunc:          nil,
+		external:            external,
+		pool:                pool,
+		storage:             storage,
+		log:                 log,
+		taskInterval:        taskInt,
+		unregisteredTimeout: time.Duration(timeout) * time.Hour,
+		maxPollBackoff:      time.Duration(maxBackoff) * time.Millisecond,
+		orderLease:          time.Duration(lease) * time.Millisecond,
+		pushWindow:          time.Duration(window) * time.Millisecond,
+		taskTimeout:         time.Duration(timeoutTask) * time.Millisecond,
 	}
 }
 
@@ -72,16 +143,42 @@ func (a *AccrualService) Start() {
 	a.cancelFunc = canselFunc
 	a.wg.Add(1)
 
-	go a.UpdateOrders(ctx)
+	go func() {
+		defer a.wg.Done()
+		a.UpdateOrders(ctx)
+	}()
 }
 
-func (a *AccrualService) Stop() {
+// Stop stops creating new tasks, waits for the queued tasks
+// until the context is done and saves the collected results.
+// The service which is not started or is already stopped is left as is.
+func (a *AccrualService) Stop(ctx context.Context) {
+	if a.cancelFunc == nil {
+		return
+	}
+
+	a.stopOnce.Do(func() {
+		a.stop(ctx)
+	})
+}
+
+func (a *AccrualService) stop(ctx context.Context) {
 	a.cancelFunc()
+
+	err := a.pool.Drain(ctx)
+	if err != nil {
+		a.log.Info("worker pool is not drained, the rest of tasks is canceled: ", zap.Error(err))
+	}
+
+	a.pool.Stop()
+
+	close(a.drained)
 	a.wg.Wait()
 }
 
 func (a *AccrualService) UpdateOrders(ctx context.Context) {
-	t := time.NewTicker(time.Duration(a.taskInterval) * time.Millisecond)
+	interval := time.Duration(a.taskInterval) * time.Millisecond
+	t := time.NewTicker(interval)
 
 	result := make([]models.ExtRespOrder, 0)
 
@@ -93,6 +190,9 @@ func (a *AccrualService) UpdateOrders(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
+			t.Stop()
+			a.flush(result)
+
 			return
 		case job := <-a.results:
 			j, ok := job.(models.ExtRespOrder)
@@ -100,13 +200,20 @@ func (a *AccrualService) UpdateOrders(ctx context.Context) {
 				result = append(result, j)
 			}
 		case <-t.C:
-			orders, err := a.storage.GetOpenOrders()
+			// the first retry of an order is made after the task interval,
+			// then the interval between polls grows exponentially
+			orders, err := a.storage.GetOpenOrders(models.PollSchedule{
+				Backoff:    interval,
+				MaxBackoff: a.maxPollBackoff,
+				Lease:      a.orderLease,
+				PushWindow: a.pushWindow,
+			})
 			if err != nil {
-				return
+				a.log.Info("cannot get open orders: ", zap.Error(err))
+			} else {
+				a.CreateOrdersTask(orders)
 			}
 
-			a.CreateOrdersTask(orders)
-
 			if len(result) != 0 {
 				a.doWork(result)
 				result = nil
@@ -115,6 +222,26 @@ func (a *AccrualService) UpdateOrders(ctx context.Context) {
 	}
 }
 
+// flush collects the results of the tasks finishing during the stop
+// and saves them when the pool is drained.
+func (a *AccrualService) flush(result []models.ExtRespOrder) {
+	for {
+		select {
+		case job := <-a.results:
+			j, ok := job.(models.ExtRespOrder)
+			if ok {
+				result = append(result, j)
+			}
+		case <-a.drained:
+			if len(result) != 0 {
+				a.doWork(result)
+			}
+
+			return
+		}
+	}
+}
+
 // AddResults adds result to pool.
 func (a *AccrualService) AddResults(result interface{}) {
 	a.results <- result
@@ -125,50 +252,185 @@ func (a *AccrualService) GetResults() <-chan interface{} {
 	return a.results
 }
 
-func (a *AccrualService) CreateOrdersTask(orders []string) {
+func (a *AccrualService) CreateOrdersTask(orders []models.DataOrder) {
 	var task *workerpool.Task
 
 	for _, o := range orders {
-		taskID := o
-		task = workerpool.NewTask(func(data interface{}) error {
-			order, ok := data.(string)
+		taskData := o
+		task = workerpool.NewTask(accrualTask, func(ctx context.Context, data interface{}) error {
+			order, ok := data.(models.DataOrder)
 			if ok { // type assertion failed
-				orderdata, err := a.external.GetExtOrderAccruel(order)
+				orderdata, err := a.getOrderAccruel(ctx, order)
 				if err != nil {
+					// throttling is not a failure of the order, the task is not
+					// retried by the pool, the order is requested again
+					// on the next tick after its poll backoff
+					var errThrottled *controllers.TooManyRequestsError
+					if errors.As(err, &errThrottled) {
+						a.log.Info("order task postponed by accrual system: ", zap.String("order", order.Number),
+							zap.Duration("retry_after", errThrottled.RetryAfter))
+
+						return nil
+					}
+
+					if errors.Is(err, breaker.ErrOpen) {
+						a.log.Info("order task postponed, accrual system is unavailable: ",
+							zap.String("order", order.Number))
+
+						return nil
+					}
+
 					return fmt.Errorf("failed to create order task: %w", err)
 				}
-				a.log.Info("processed task: ", zap.String("order", order))
-				a.AddResults(orderdata)
+				a.log.Info("processed task: ", zap.String("order", order.Number))
+
+				// the result is not needed anymore if the service is stopped
+				select {
+				case a.results <- orderdata:
+				case <-ctx.Done():
+					return fmt.Errorf("failed to add order result: %w", ctx.Err())
+				}
 			}
 
 			return nil
-		}, taskID)
-		a.pool.AddTask(task)
+		}, taskData, a.taskTimeout)
+		// the order is not queued again while the previous task is not done
+		task.Key = o.Number
+
+		// the ticker is not blocked by the full queue, the orders
+		// which are not queued are requested again after their lease
+		if err := a.pool.AddTask(task); err != nil {
+			a.log.Info("order tasks are not queued: ", zap.String("order", o.Number), zap.Error(err))
+
+			return
+		}
+	}
+}
+
+// PushOrder applies the order update pushed by the accrual system.
+// Polling of the order is postponed for the push window.
+func (a *AccrualService) PushOrder(order models.ExtRespOrder) error {
+	status, ok := orderStatus(order.Status)
+	if !ok {
+		return controllers.ErrUnknownAccrualStatus
+	}
+
+	order.Status = status
+
+	exists, err := a.storage.HasOrder(order.Order)
+	if err != nil {
+		return fmt.Errorf("failed to push order: %w", err)
+	}
+
+	if !exists {
+		return storage.ErrNotFound
+	}
+
+	changed, err := a.storage.UpdateOrderStatus([]models.ExtRespOrder{order})
+	if err != nil {
+		return fmt.Errorf("failed to push order: %w", err)
+	}
+
+	// the points are accrued only when the order has just become processed
+	if orders := processedOrders([]models.ExtRespOrder{order}, changed); len(orders) != 0 {
+		err = a.storage.InsertAccruel(orders)
+		if err != nil {
+			return fmt.Errorf("failed to push order: %w", err)
+		}
+	}
+
+	err = a.storage.SetOrderPushed(order.Order)
+	if err != nil {
+		return fmt.Errorf("failed to push order: %w", err)
+	}
+
+	return nil
+}
+
+// getOrderAccruel requests the order from the accrual system
+// and converts the answer to the order status of our system.
+func (a *AccrualService) getOrderAccruel(ctx context.Context,
+	order models.DataOrder,
+) (models.ExtRespOrder, error) {
+	orderdata, err := a.external.GetExtOrderAccruel(ctx, order.Number)
+	if errors.Is(err, controllers.ErrOrderNotRegistered) {
+		// the order stays new until the accrual system registers it,
+		// but we don't wait for it forever
+		status := "NEW"
+		if time.Since(order.Date) > a.unregisteredTimeout {
+			status = "INVALID"
+		}
+
+		return models.ExtRespOrder{Order: order.Number, Status: status}, nil
+	}
+
+	if err != nil {
+		return models.ExtRespOrder{}, err
+	}
+
+	status, ok := orderStatus(orderdata.Status)
+	if !ok {
+		return models.ExtRespOrder{}, fmt.Errorf("%w %q of order %s",
+			controllers.ErrUnknownAccrualStatus, orderdata.Status, order.Number)
+	}
+
+	orderdata.Order = order.Number
+	orderdata.Status = status
+
+	return orderdata, nil
+}
+
+// orderStatus maps the accrual system status to the order status.
+func orderStatus(accrualStatus string) (string, bool) {
+	switch accrualStatus {
+	case "REGISTERED", "PROCESSING":
+		return "PROCESSING", true
+	case "INVALID":
+		return "INVALID", true
+	case "PROCESSED":
+		return "PROCESSED", true
+	default:
+		return "", false
 	}
 }
 
 func (a *AccrualService) doWork(result []models.ExtRespOrder) {
 	// perform a group update of the orders table (status field)
-	err := a.storage.UpdateOrderStatus(result)
+	changed, err := a.storage.UpdateOrderStatus(result)
 	if err != nil {
 		a.log.Info("errors when updating order status: ", zap.Error(err))
+
+		return
 	}
 
-	// add records with accruel to savings_account
-	var dmx sync.RWMutex
+	// add records with accruel to the ledger
+	orders := processedOrders(result, changed)
+	if len(orders) == 0 {
+		return
+	}
 
-	orders := make(map[string]models.ExtRespOrder, 0)
+	err = a.storage.InsertAccruel(orders)
+	if err != nil {
+		a.log.Info("errors when accruel inserting: ", zap.Error(err))
+	}
+}
+
+// processedOrders returns the orders with accrual which have just become
+// processed. The accrual of an order in another status or of an order
+// which has already been final is not credited.
+func processedOrders(result []models.ExtRespOrder, changed []string) map[string]models.ExtRespOrder {
+	moved := make(map[string]bool, len(changed))
+	for _, number := range changed {
+		moved[number] = true
+	}
+
+	orders := make(map[string]models.ExtRespOrder)
 
 	for _, o := range result {
-		if o.Accrual != 0 {
-			dmx.RLock()
+		if o.Status == "PROCESSED" && o.Accrual != 0 && moved[o.Order] {
 			orders[o.Order] = o
-			dmx.RUnlock()
 		}
 	}
 
-	err = a.storage.InsertAccruel(orders)
-	if err != nil {
-		a.log.Info("errors when accruel inserting: ", zap.Error(err))
-	}
+	return orders
 }
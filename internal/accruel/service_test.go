@@ -0,0 +1,192 @@
+package accruel
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/wurt83ow/gophermart/internal/accrualstub"
+	"github.com/wurt83ow/gophermart/internal/breaker"
+	"github.com/wurt83ow/gophermart/internal/controllers"
+	"github.com/wurt83ow/gophermart/internal/models"
+	"github.com/wurt83ow/gophermart/internal/ratelimit"
+	"github.com/wurt83ow/gophermart/internal/workerpool"
+	"go.uber.org/zap"
+)
+
+// newStubService starts the accrual stub and the pool
+// and returns the service requesting the stub.
+func newStubService(t *testing.T, cfg accrualstub.Config) (*AccrualService, *workerpool.Pool) {
+	t.Helper()
+
+	log := zap.NewNop()
+	srv := httptest.NewServer(accrualstub.NewServer(cfg, log).Route())
+	t.Cleanup(srv.Close)
+
+	option := func(v string) func() string {
+		return func() string { return v }
+	}
+
+	cb := breaker.NewCircuitBreaker(option("3"), option("60000"), log)
+	limiter := ratelimit.NewLimiter(option("60000"), log)
+	external := controllers.NewExtController(nil, option(srv.URL), cb, limiter, log)
+
+	pool := workerpool.NewPool(nil, option("2"), log, option("100"))
+	go pool.RunBackground()
+	t.Cleanup(pool.Stop)
+
+	a := NewAccrualService(external, pool, nil, log, option("100"), option("24"),
+		option("60000"), option("60000"), option("0"), option("1000"))
+
+	return a, pool
+}
+
+// drainPool waits until the pool has finished the tasks.
+func drainPool(t *testing.T, pool *workerpool.Pool) workerpool.Stats {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := pool.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	return pool.Stats()
+}
+
+func TestGetOrderAccruel(t *testing.T) {
+	a, _ := newStubService(t, accrualstub.Config{
+		Rules:           []accrualstub.Rule{{Match: "default", Reward: 500, RewardType: "pt"}},
+		DefaultGoods:    []accrualstub.Good{{Description: "default"}},
+		Unregistered:    []string{"79927398713"},
+		Invalid:         []string{"2377225624"},
+		ProcessingPolls: 2,
+	})
+
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+
+	tests := []struct {
+		name  string
+		order models.DataOrder
+		want  models.ExtRespOrder
+	}{
+		{"registered", models.DataOrder{Number: "12345678903", Date: now},
+			models.ExtRespOrder{Order: "12345678903", Status: "PROCESSING"}},
+		{"processing", models.DataOrder{Number: "12345678903", Date: now},
+			models.ExtRespOrder{Order: "12345678903", Status: "PROCESSING"}},
+		{"processed", models.DataOrder{Number: "12345678903", Date: now},
+			models.ExtRespOrder{Order: "12345678903", Status: "PROCESSED", Accrual: 500}},
+		{"not registered", models.DataOrder{Number: "79927398713", Date: now},
+			models.ExtRespOrder{Order: "79927398713", Status: "NEW"}},
+		{"not registered for too long", models.DataOrder{Number: "79927398713", Date: old},
+			models.ExtRespOrder{Order: "79927398713", Status: "INVALID"}},
+		{"invalid registered", models.DataOrder{Number: "2377225624", Date: now},
+			models.ExtRespOrder{Order: "2377225624", Status: "PROCESSING"}},
+		{"invalid processing", models.DataOrder{Number: "2377225624", Date: now},
+			models.ExtRespOrder{Order: "2377225624", Status: "PROCESSING"}},
+		{"invalid", models.DataOrder{Number: "2377225624", Date: now},
+			models.ExtRespOrder{Order: "2377225624", Status: "INVALID"}},
+	}
+
+	for _, tt := range tests {
+		got, err := a.getOrderAccruel(context.Background(), tt.order)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tt.name, err)
+		}
+
+		if got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCreateOrdersTaskResults(t *testing.T) {
+	a, pool := newStubService(t, accrualstub.Config{
+		Rules:        []accrualstub.Rule{{Match: "default", Reward: 500, RewardType: "pt"}},
+		DefaultGoods: []accrualstub.Good{{Description: "default"}},
+		LatencyMS:    50,
+	})
+
+	orders := []models.DataOrder{
+		{Number: "12345678903", Date: time.Now()},
+		{Number: "2377225624", Date: time.Now()},
+	}
+	a.CreateOrdersTask(orders)
+
+	got := make(map[string]models.ExtRespOrder)
+
+	for len(got) < len(orders) {
+		select {
+		case job := <-a.GetResults():
+			o := job.(models.ExtRespOrder)
+			got[o.Order] = o
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d results, want %d", len(got), len(orders))
+		}
+	}
+
+	for _, o := range orders {
+		want := models.ExtRespOrder{Order: o.Number, Status: "PROCESSED", Accrual: 500}
+		if got[o.Number] != want {
+			t.Errorf("got %+v, want %+v", got[o.Number], want)
+		}
+	}
+
+	stats := drainPool(t, pool)
+	if stats.Failed != 0 || stats.InFlight != 0 {
+		t.Errorf("got %d failed and %d in flight tasks, want none", stats.Failed, stats.InFlight)
+	}
+}
+
+func TestCreateOrdersTaskTooManyRequests(t *testing.T) {
+	// every request is answered with 429
+	a, pool := newStubService(t, accrualstub.Config{
+		DefaultGoods: []accrualstub.Good{{Description: "default"}},
+		BurstEvery:   1,
+		BurstLength:  1,
+		RetryAfter:   0,
+	})
+
+	a.CreateOrdersTask([]models.DataOrder{
+		{Number: "12345678903", Date: time.Now()},
+		{Number: "2377225624", Date: time.Now()},
+	})
+
+	stats := drainPool(t, pool)
+
+	// the postponed tasks are neither retried nor dead-lettered,
+	// their keys are released for the next tick
+	if stats.Processed != 2 {
+		t.Errorf("got %d processed tasks, want 2", stats.Processed)
+	}
+
+	if stats.Failed != 0 || stats.DeadLetters != 0 || stats.InFlight != 0 {
+		t.Errorf("got %d failed, %d dead-lettered and %d in flight tasks, want none",
+			stats.Failed, stats.DeadLetters, stats.InFlight)
+	}
+
+	select {
+	case job := <-a.GetResults():
+		t.Errorf("got result %+v of a throttled order", job)
+	default:
+	}
+}
+
+func TestStop(t *testing.T) {
+	a, _ := newStubService(t, accrualstub.Config{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the service which is not started is not stopped
+	a.Stop(ctx)
+
+	a.Start()
+
+	// the second stop does nothing
+	a.Stop(ctx)
+	a.Stop(ctx)
+}
@@ -0,0 +1,149 @@
+package accruel
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wurt83ow/gophermart/internal/controllers"
+	"github.com/wurt83ow/gophermart/internal/models"
+	"go.uber.org/zap"
+)
+
+const webhookSecret = "secret"
+
+// orderStorage keeps the order statuses the way the keeper does:
+// a final status does not change and the accrual is credited once.
+type orderStorage struct {
+	mx       sync.Mutex
+	statuses map[string]string
+	accruals map[string]models.Money
+}
+
+func (s *orderStorage) GetOpenOrders(models.PollSchedule) ([]models.DataOrder, error) {
+	return nil, nil
+}
+
+func (s *orderStorage) UpdateOrderStatus(result []models.ExtRespOrder) ([]string, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	changed := make([]string, 0)
+
+	for _, o := range result {
+		old := s.statuses[o.Order]
+		if old == "PROCESSED" || old == "INVALID" || old == o.Status {
+			continue
+		}
+
+		s.statuses[o.Order] = o.Status
+		changed = append(changed, o.Order)
+	}
+
+	return changed, nil
+}
+
+func (s *orderStorage) InsertAccruel(orders map[string]models.ExtRespOrder) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	for number, o := range orders {
+		if _, exists := s.accruals[number]; !exists {
+			s.accruals[number] = o.Accrual
+		}
+	}
+
+	return nil
+}
+
+func (s *orderStorage) SetOrderPushed(string) error {
+	return nil
+}
+
+func (s *orderStorage) HasOrder(number string) (bool, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	_, exists := s.statuses[number]
+
+	return exists, nil
+}
+
+// pushOrder sends the signed update to the webhook and returns the response status.
+func pushOrder(t *testing.T, srv *httptest.Server, body string) int {
+	t.Helper()
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(webhookSecret))
+	mac.Write([]byte(timestamp + "." + body))
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/webhook", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("X-Timestamp", timestamp)
+	req.Header.Set("X-Signature", hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func TestPushOrderAccrual(t *testing.T) {
+	store := &orderStorage{
+		statuses: map[string]string{"12345678903": "NEW"},
+		accruals: make(map[string]models.Money),
+	}
+
+	log := zap.NewNop()
+	a := &AccrualService{storage: store, log: log}
+	srv := httptest.NewServer(controllers.NewWebhookController(a, webhookSecret, log).Route())
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name    string
+		body    string
+		code    int
+		status  string
+		accrual models.Money
+	}{
+		{"processing with accrual", `{"order":"12345678903","status":"PROCESSING","accrual":1}`,
+			http.StatusOK, "PROCESSING", 0},
+		{"processed", `{"order":"12345678903","status":"PROCESSED","accrual":5}`,
+			http.StatusOK, "PROCESSED", 500},
+		{"processed again", `{"order":"12345678903","status":"PROCESSED","accrual":7}`,
+			http.StatusOK, "PROCESSED", 500},
+		{"invalid after processed", `{"order":"12345678903","status":"INVALID","accrual":7}`,
+			http.StatusOK, "PROCESSED", 500},
+		{"unknown order", `{"order":"2377225624","status":"PROCESSED","accrual":5}`,
+			http.StatusNotFound, "PROCESSED", 500},
+	}
+
+	for _, tt := range tests {
+		if code := pushOrder(t, srv, tt.body); code != tt.code {
+			t.Fatalf("%s: got status code %d, want %d", tt.name, code, tt.code)
+		}
+
+		store.mx.Lock()
+		status, accrual := store.statuses["12345678903"], store.accruals["12345678903"]
+		store.mx.Unlock()
+
+		if status != tt.status || accrual != tt.accrual {
+			t.Errorf("%s: got order %s with accrual %v, want %s with accrual %v",
+				tt.name, status, accrual, tt.status, tt.accrual)
+		}
+	}
+}
@@ -11,24 +11,35 @@ import (
 	"github.com/wurt83ow/gophermart/internal/accruel"
 	authz "github.com/wurt83ow/gophermart/internal/authorization"
 	"github.com/wurt83ow/gophermart/internal/bdkeeper"
+	"github.com/wurt83ow/gophermart/internal/breaker"
 	"github.com/wurt83ow/gophermart/internal/config"
 	"github.com/wurt83ow/gophermart/internal/controllers"
+	"github.com/wurt83ow/gophermart/internal/expiry"
 	"github.com/wurt83ow/gophermart/internal/logger"
 	"github.com/wurt83ow/gophermart/internal/middleware"
+	"github.com/wurt83ow/gophermart/internal/ratelimit"
 	"github.com/wurt83ow/gophermart/internal/storage"
 	"github.com/wurt83ow/gophermart/internal/workerpool"
 	"go.uber.org/zap"
 )
 
+// drainTimeout limits the time of finishing the accrual tasks on shutdown.
+const drainTimeout = 10 * time.Second
+
 type AppServer struct {
-	ctx context.Context
-	srv *http.Server
-	db  *pgxpool.Pool
+	ctx     context.Context
+	srv     *http.Server
+	db      *pgxpool.Pool
+	keeper  storage.Keeper
+	accrual *accruel.AccrualService
+	expiry  *expiry.ExpiryService
+	done    chan struct{}
 }
 
 func NewServer(ctx context.Context) *AppServer {
 	server := new(AppServer)
 	server.ctx = ctx
+	server.done = make(chan struct{})
 	return server
 }
 
@@ -47,8 +58,8 @@ func (server *AppServer) Serve() {
 	var keeper storage.Keeper
 	if option.DataBaseDSN() != "" {
 		keeper = bdkeeper.NewBDKeeper(option.DataBaseDSN, nLogger)
-		defer keeper.Close()
 	}
+	server.keeper = keeper
 
 	// initialize the storage instance
 	memoryStorage := storage.NewMemoryStorage(keeper, nLogger)
@@ -61,9 +72,19 @@ func (server *AppServer) Serve() {
 	// create a new NewJWTAuthz for user authorization
 	authz := authz.NewJWTAuthz(option.JWTSigningKey(), nLogger)
 
+	// create a new circuit breaker to protect the accrual system client
+	accrualBreaker := breaker.NewCircuitBreaker(option.BreakerThreshold,
+		option.BreakerCooldown, nLogger)
+
+	// create a new service expiring the points after their lifetime
+	expiryService := expiry.NewExpiryService(memoryStorage, nLogger,
+		option.PointsLifetime, option.ExpiringWindow)
+	expiryService.Start()
+	server.expiry = expiryService
+
 	// create a new controller to process incoming requests
 	basecontr := controllers.NewBaseController(memoryStorage, option,
-		nLogger, authz)
+		nLogger, authz, accrualBreaker, expiryService)
 
 	// get a middleware for logging requests
 	reqLog := middleware.NewReqLog(nLogger)
@@ -71,19 +92,35 @@ func (server *AppServer) Serve() {
 	// start the worker pool in the background
 	go pool.RunBackground()
 
+	// create a new rate limiter shared by all requests to the accrual system
+	accrualLimiter := ratelimit.NewLimiter(option.RateLimit, nLogger)
+
 	// create a new controller for creating outgoing requests
 	extcontr := controllers.NewExtController(memoryStorage,
-		option.AccrualSystemAddress, nLogger)
+		option.AccrualSystemAddress, accrualBreaker, accrualLimiter, nLogger)
 
 	accruelServise := accruel.NewAccrualService(extcontr, pool, memoryStorage,
-		nLogger, option.TaskExecutionInterval)
+		nLogger, option.TaskExecutionInterval, option.UnregisteredTimeout,
+		option.MaxPollBackoff, option.OrderLeaseTimeout, option.PushWindow,
+		option.TaskTimeout)
 	accruelServise.Start()
+	server.accrual = accruelServise
+
+	// create a new controller for updates pushed by the accrual system
+	webhookcontr := controllers.NewWebhookController(accruelServise,
+		option.WebhookSecret(), nLogger)
+
+	// create a new controller for service operations
+	admincontr := controllers.NewAdminController(pool, memoryStorage, option.AdminToken(),
+		option.ReversalPolicy(), nLogger)
 
 	r := chi.NewRouter()
 	r.Use(reqLog.RequestLogger)
 	// r.Use(middleware.GzipMiddleware)
 
 	r.Mount("/", basecontr.Route())
+	r.Mount("/api/accrual", webhookcontr.Route())
+	r.Mount("/api/admin", admincontr.Route())
 
 	flagRunAddr := option.RunAddr()
 	nLogger.Info("Running server", zap.String("address", flagRunAddr))
@@ -99,27 +136,40 @@ func (server *AppServer) Serve() {
 		log.Fatalln(err)
 	}
 
+	// wait for the shutdown to finish
+	<-server.done
 }
 
+// Shutdown stops the server in order: stops accepting HTTP requests,
+// stops the accrual service draining its tasks, stops the expiry job
+// and closes the keeper.
 func (server *AppServer) Shutdown() {
+	defer close(server.done)
+
 	log.Printf("server stopped")
 
 	ctxShutDown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	// server.db.Close()
+	if err := server.srv.Shutdown(ctxShutDown); err != nil {
+		log.Printf("server Shutdown Failed:%s", err)
+	}
 
-	defer func() {
-		cancel()
-	}()
+	if server.accrual != nil {
+		ctxDrain, cancelDrain := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancelDrain()
 
-	var err error
-	if err = server.srv.Shutdown(ctxShutDown); err != nil {
-		log.Fatalf("server Shutdown Failed:%s", err)
+		server.accrual.Stop(ctxDrain)
+		log.Printf("accrual service stopped")
 	}
 
-	log.Printf("server exited properly")
+	if server.expiry != nil {
+		server.expiry.Stop()
+	}
 
-	if err == http.ErrServerClosed {
-		err = nil
+	if server.keeper != nil {
+		server.keeper.Close()
 	}
+
+	log.Printf("server exited properly")
 }
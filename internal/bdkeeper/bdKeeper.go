@@ -5,7 +5,6 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"math"
 	"os"
 	"strings"
 	"time"
@@ -23,13 +22,17 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
+// pingTimeout limits the time of checking the database connection.
+const pingTimeout = time.Second
+
 type Log interface {
 	Info(string, ...zapcore.Field)
 }
 
 type BDKeeper struct {
-	conn *sql.DB
-	log  Log
+	conn       *sql.DB
+	log        Log
+	instanceID string
 }
 
 func NewBDKeeper(dsn func() string, log Log) *BDKeeper {
@@ -85,6 +88,8 @@ func NewBDKeeper(dsn func() string, log Log) *BDKeeper {
 	return &BDKeeper{
 		conn: conn,
 		log:  log,
+		// identifies this instance as the owner of leased orders
+		instanceID: uuid.New().String(),
 	}
 }
 
@@ -101,6 +106,7 @@ func (kp *BDKeeper) GetUserWithdrawals(userID string) ([]models.DataWithdraw, er
 		savings_account
 	WHERE
 		accrual < 0
+		AND id_order_out IS NOT NULL
 		AND user_id = $1
 	GROUP BY
 		id_order_out,
@@ -139,59 +145,90 @@ func (kp *BDKeeper) GetUserWithdrawals(userID string) ([]models.DataWithdraw, er
 func (kp *BDKeeper) GetUserBalance(userID string) (models.DataBalance, error) {
 	ctx := context.Background()
 
+	// the balance is materialized alongside the ledger postings,
+	// a user without accruals has no balance row yet
 	sql := `
 	SELECT
-		SUM(sq.current) AS current,
-		- SUM(sq.withdrawn) AS withdrawn
-	FROM (
-		SELECT
-			SUM(accrual)
-			CURRENT,
-			0 withdrawn
-		FROM
-			savings_account
-		WHERE
-			user_id = $1
-		UNION
-		SELECT
-			0,
-			SUM(accrual)
-		FROM
-			savings_account
-		WHERE
-			user_id = $1
-			AND accrual < 0) AS sq`
+		COALESCE(MAX(current), 0) AS current,
+		COALESCE(MAX(withdrawn), 0) AS withdrawn,
+		COALESCE(MAX(debt), 0) AS debt,
+		COALESCE(MAX(held), 0) AS held
+	FROM
+		balances
+	WHERE
+		user_id = $1`
 	row := kp.conn.QueryRowContext(ctx, sql, userID)
 
 	// read the values from the database record into the corresponding fields of the structure
 	var m models.DataBalance
 
-	err := row.Scan(&m.Current, &m.Withdrawn)
+	err := row.Scan(&m.Current, &m.Withdrawn, &m.Debt, &m.Held)
 	if err != nil {
 		kp.log.Info("row scan error: ", zap.Error(err))
 
 		return models.DataBalance{}, fmt.Errorf("failed to get user balance by userID: %w", err)
 	}
 
+	// the held points can not be spent until the hold is released
+	m.Available = m.Current - m.Held
+
 	return m, nil
 }
 
-func (kp *BDKeeper) GetOpenOrders() ([]string, error) {
+// GetOpenOrders leases the not final orders which are due to be polled,
+// the most overdue first, and schedules their next poll with exponential backoff.
+func (kp *BDKeeper) GetOpenOrders(schedule models.PollSchedule) ([]models.DataOrder, error) {
 	ctx := context.Background()
 
-	// get orders from bd
+	// 1. Select orders whose poll time has come and which are not leased
+	// by another instance (or whose lease has expired), the most overdue first.
+	// Orders uploaded or pushed by the accrual system within the push window
+	// are left to the push updates.
+	// Rows locked by a concurrent select of another instance are skipped.
+	// 2. Lease them to this instance until the result is written.
+	// 3. Immediately move their next poll time forward by
+	// backoff * 2^attempts (but not more than maxBackoff) with a random jitter,
+	// so a stuck order doesn't hold the place of the others.
 	sql := `
-	SELECT
-		number
+	WITH _due AS (
+		SELECT
+			order_id
+		FROM
+			orders
+		WHERE
+			status <> 'INVALID'
+			AND status <> 'PROCESSED'
+			AND number <> ''
+			AND next_poll_at <= CURRENT_TIMESTAMP
+			AND COALESCE(pushed_at, date) <= CURRENT_TIMESTAMP - $5 * INTERVAL '1 millisecond'
+			AND (lease_until IS NULL
+				OR lease_until <= CURRENT_TIMESTAMP)
+		ORDER BY
+			next_poll_at
+		LIMIT 100
+		FOR UPDATE
+			SKIP LOCKED
+	)
+	UPDATE
+		orders
+	SET
+		attempts = orders.attempts + 1,
+		next_poll_at = CURRENT_TIMESTAMP + LEAST($1 * power(2, LEAST(orders.attempts, 30)), $2)
+			* (0.5 + random() / 2) * INTERVAL '1 millisecond',
+		lease_owner = $3,
+		lease_until = CURRENT_TIMESTAMP + $4 * INTERVAL '1 millisecond'
 	FROM
-		public.orders
+		_due
 	WHERE
-		status <> 'INVALID'
-		AND status <> 'PROCESSED'
-		AND number <> ''
-	LIMIT 100`
+		orders.order_id = _due.order_id
+	RETURNING
+		orders.number,
+		orders.date,
+		orders.attempts`
 
-	rows, err := kp.conn.QueryContext(ctx, sql)
+	rows, err := kp.conn.QueryContext(ctx, sql, schedule.Backoff.Milliseconds(),
+		schedule.MaxBackoff.Milliseconds(), kp.instanceID, schedule.Lease.Milliseconds(),
+		schedule.PushWindow.Milliseconds())
 	if err != nil {
 		return nil, fmt.Errorf("failed to get open orders: %w", err)
 	}
@@ -202,17 +239,17 @@ func (kp *BDKeeper) GetOpenOrders() ([]string, error) {
 
 	defer rows.Close()
 
-	orders := make([]string, 0)
+	orders := make([]models.DataOrder, 0)
 
 	for rows.Next() {
-		var m models.ExtRespOrder
+		var m models.DataOrder
 
-		err := rows.Scan(&m.Order)
+		err := rows.Scan(&m.Number, &m.Date, &m.Attempts)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get open orders: %w", err)
 		}
 
-		orders = append(orders, m.Order)
+		orders = append(orders, m)
 	}
 
 	return orders, nil
@@ -316,11 +353,22 @@ func (kp *BDKeeper) SaveOrder(key string, order models.DataOrder) (models.DataOr
 		id = order.UUID
 	}
 
+	// the initial status gets to the order history in the same statement,
+	// so a conflicting order leaves no history row behind
 	sql := `
-	INSERT INTO orders (order_id, number, date, status, user_id)
-		VALUES ($1, $2, $3, $4, $5)
-	RETURNING
-		order_id`
+	WITH _order AS (
+		INSERT INTO orders (order_id, number, date, status, user_id)
+			VALUES ($1, $2, $3, $4, $5)
+		RETURNING
+			number,
+			status)
+	INSERT INTO order_status_history (number, status, changed_at)
+	SELECT
+		number,
+		status,
+		CURRENT_TIMESTAMP
+	FROM
+		_order`
 	_, err := kp.conn.ExecContext(ctx, sql,
 		id, order.Number, order.Date, order.Status, order.UserID)
 
@@ -426,9 +474,52 @@ func (kp *BDKeeper) SaveUser(key string, data models.DataUser) (models.DataUser,
 	return m, nil
 }
 
+// FindUser returns the user with the login, or storage.ErrNotFound
+// if there is no such user in the database.
+func (kp *BDKeeper) FindUser(login string) (models.DataUser, error) {
+	ctx := context.Background()
+
+	query := `
+	SELECT
+		user_id,
+		email,
+		name
+	FROM
+		users
+	WHERE
+		email = $1`
+	row := kp.conn.QueryRowContext(ctx, query, login)
+
+	var m models.DataUser
+
+	err := row.Scan(&m.UUID, &m.Email, &m.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return m, storage.ErrNotFound
+	}
+
+	if err != nil {
+		return m, fmt.Errorf("failed to find user: %w", err)
+	}
+
+	return m, nil
+}
+
 func (kp *BDKeeper) Withdraw(withdraw models.DataWithdraw) error {
 	ctx := context.Background()
 
+	// the balance is locked optimistically, so the withdrawal is repeated
+	// when a concurrent transaction has changed the balance in the meantime
+	err := kp.retryOnBalanceChange(zap.String("user", withdraw.UserID), func() error {
+		return kp.withdraw(ctx, withdraw)
+	})
+	if errors.Is(err, errBalanceChanged) {
+		return fmt.Errorf("failed to withdraw: %w", err)
+	}
+
+	return err
+}
+
+func (kp *BDKeeper) withdraw(ctx context.Context, withdraw models.DataWithdraw) error {
 	// start the transaction
 	tx, err := kp.conn.BeginTx(ctx, nil)
 	if err != nil {
@@ -442,153 +533,116 @@ func (kp *BDKeeper) Withdraw(withdraw models.DataWithdraw) error {
 		}
 	}()
 
-	Args := []interface{}{withdraw.UserID}
-
-	// 1.Создадим дополнительный select, чтобы заблокировать все записи
-	// покупателя для изменения, так как "FOR UPDATE" не работате со
-	// сгруппированными строками.
-	// 2.Получим таблицу всех баллов по покупателю в разрезе заказов,
-	// а так же, в колонке user_accruel все баллы по покупателю в целом.
-	// 3.Соединим сгруппированную таблицу со вложенным запросом, чтобы получить
-	// все накопленные баллы покупателя.
-	// 4. Упорядочим строки по дате заказа (получим дату дополнительным левым соединением)
-	sql := `
-	WITH _orders AS (
-		SELECT
-			*
-		FROM
-			orders
-		WHERE
-			user_id = $1
-		FOR UPDATE
-	)
-	SELECT
-		sa.user_id,
-		sa.id_order_in AS number,
-		_orders.date AS date,
-		SUM(sa.accrual) AS accrual,
-		nq.user_accrual
-	FROM
-		savings_account AS sa
-		INNER JOIN _orders AS _orders ON sa.id_order_in = _orders.number
-		INNER JOIN (
-			SELECT
-				user_id,
-				SUM(accrual) AS user_accrual
-			FROM
-				savings_account
-			WHERE
-				user_id = $1
-			GROUP BY
-				user_id) AS nq ON nq.user_id = sa.user_id
-	WHERE
-		sa.user_id = $1
-	GROUP BY
-		sa.user_id,
-		sa.id_order_in,
-		_orders.date,
-		nq.user_accrual
-	ORDER BY
-		_orders.date ASC`
+	// Зарегистрируем списание. Уникальные индексы по номеру заказа и ключу
+	// идемпотентности покупателя не дадут списать баллы повторно: повторный
+	// запрос дождется фиксации первой транзакции и получит ее результат.
+	replay, err := kp.registerWithdraw(ctx, tx, withdraw)
+	if err != nil || replay {
+		return err
+	}
 
-	rows, err := tx.QueryContext(ctx, sql, Args...)
+	// Прочитаем остаток покупателя и его версию без блокировки.
+	// Вернем ошибку, если доступных баллов меньше, чем запрошено к списанию.
+	balance, version, err := kp.getBalance(ctx, tx, withdraw.UserID)
 	if err != nil {
 		return fmt.Errorf("failed to withdraw: %w", err)
 	}
 
-	if rows.Err() != nil {
-		return fmt.Errorf("failed to withdraw: %w", err)
+	if balance.Current-balance.Held < withdraw.Sum {
+		return storage.ErrInsufficient
 	}
 
-	defer rows.Close()
-
-	valueStrings := make([]string, 0)
-	valueArgs := make([]interface{}, 0)
-
-	leftWrite := withdraw.Sum
-	idx := 0
-
-	for rows.Next() {
-		if leftWrite <= 0 {
-			break
-		}
-
-		var m models.DataOrder
+	err = kp.writeWithdrawal(ctx, tx, withdraw, version, 0)
+	if err != nil {
+		return err
+	}
 
-		err := rows.Scan(&m.UserID, &m.Number,
-			&m.Date, &m.Accrual, &m.UserAccrual)
-		if err != nil {
-			return fmt.Errorf("failed to withdraw: %w", err)
-		}
+	// commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to get user withdrawls by userID: %w", err)
+	}
 
-		// Вернем ошибку, если сумма всех накопленных баллов пользователя меньше, чем
-		// сумма запрошенная к списанию
-		if m.UserAccrual < withdraw.Sum {
-			return storage.ErrInsufficient
-		}
+	return nil
+}
 
-		// Создадим строки с минусом для каждой строки заказа
-		// и вычтем сумму списания из "ОсталосьСписать"
-		accrual := float32(math.Min(float64(leftWrite), float64(m.Accrual)))
-		leftWrite -= accrual
+// registerWithdraw saves the withdrawal header in the transaction.
+// It returns true if the same withdrawal has already been made,
+// and storage.ErrConflict if the order or the idempotency key
+// were used by another withdrawal of the user.
+func (kp *BDKeeper) registerWithdraw(ctx context.Context, tx *sql.Tx, withdraw models.DataWithdraw) (bool, error) {
+	var key interface{}
+	if withdraw.IdempotencyKey != "" {
+		key = withdraw.IdempotencyKey
+	}
 
-		valueStrings = append(valueStrings,
-			fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)",
-				idx*5+1, idx*5+2, idx*5+3, idx*5+4, idx*5+5))
+	sql := `
+	INSERT INTO withdrawals (user_id, number, idempotency_key, sum, processed_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+	ON CONFLICT
+		DO NOTHING`
 
-		valueArgs = append(valueArgs, withdraw.UserID)
-		valueArgs = append(valueArgs, time.Now())
-		valueArgs = append(valueArgs, m.Number)
-		valueArgs = append(valueArgs, withdraw.Order)
-		valueArgs = append(valueArgs, -accrual)
-		idx++
+	res, err := tx.ExecContext(ctx, sql, withdraw.UserID, withdraw.Order, key, withdraw.Sum)
+	if err != nil {
+		return false, fmt.Errorf("failed to register withdraw: %w", err)
 	}
 
-	// Запишем набор на списание баллов с минусом.
-	sql = `
-	INSERT INTO savings_account (user_id, processed_at, id_order_in, id_order_out, accrual)
-    VALUES %s`
-	sql = fmt.Sprintf(sql, strings.Join(valueStrings, ","))
-	_, err = kp.conn.ExecContext(ctx, sql, valueArgs...)
-
+	inserted, err := res.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("failed to withdraw: %w", err)
+		return false, fmt.Errorf("failed to register withdraw: %w", err)
+	}
+
+	if inserted != 0 {
+		return false, nil
 	}
 
-	// На всякий случай, после записи нашего набора, проверим остаток по покупателю в целом
-	// и если он вдруг окажется меньше нуля, то вернем ошибку, следовательно не произойдет фиксация
-	// транзакции, она откатится и запись в базу будет отменена.
 	sql = `
 	SELECT
-		SUM(accrual) AS accrual
+		number,
+		sum
 	FROM
-		savings_account
+		withdrawals
 	WHERE
-		user_id = $1`
-	row := kp.conn.QueryRowContext(ctx, sql, withdraw.UserID)
+		user_id = $1
+		AND (number = $2
+			OR idempotency_key = $3)`
+	rows, err := tx.QueryContext(ctx, sql, withdraw.UserID, withdraw.Order, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to register withdraw: %w", err)
+	}
 
-	// read the values from the database record into the corresponding fields of the structure
-	var m models.ExtRespOrder
-	err = row.Scan(&m.Accrual)
+	defer rows.Close()
 
-	if err != nil {
-		return fmt.Errorf("failed to withdraw: %w", err)
+	replay := false
+
+	for rows.Next() {
+		var m models.DataWithdraw
+
+		if err := rows.Scan(&m.Order, &m.Sum); err != nil {
+			return false, fmt.Errorf("failed to register withdraw: %w", err)
+		}
+
+		if m.Order != withdraw.Order || m.Sum != withdraw.Sum {
+			return false, storage.ErrConflict
+		}
+
+		replay = true
 	}
 
-	if m.Accrual < 0 {
-		return storage.ErrInsufficient
+	if err = rows.Err(); err != nil {
+		return false, fmt.Errorf("failed to register withdraw: %w", err)
 	}
 
-	// commit the transaction
-	err = tx.Commit()
-	if err != nil {
-		return fmt.Errorf("failed to get user withdrawls by userID: %w", err)
+	if !replay {
+		return false, fmt.Errorf("failed to register withdraw: %w", storage.ErrConflict)
 	}
 
-	return nil
+	return true, nil
 }
 
-func (kp *BDKeeper) UpdateOrderStatus(result []models.ExtRespOrder) error {
+// UpdateOrderStatus saves the statuses of the orders, it returns
+// the numbers of the orders whose status has actually changed.
+func (kp *BDKeeper) UpdateOrderStatus(result []models.ExtRespOrder) ([]string, error) {
 	ctx := context.Background()
 
 	valueStrings := make([]string, 0, len(result))
@@ -600,25 +654,124 @@ func (kp *BDKeeper) UpdateOrderStatus(result []models.ExtRespOrder) error {
 		valueArgs = append(valueArgs, v.Status)
 	}
 
+	// release the lease of this instance, the lease taken over
+	// by another instance after expiration stays untouched.
+	// The orders are locked before the update, so a concurrent update
+	// waits and sees the new status as the old one, and only the actual
+	// changes get to the order history. The status never goes back.
+	valueArgs = append(valueArgs, kp.instanceID)
+
 	sql := `
 	WITH _data (
 		number,
 		status
 	) AS (
-		VALUES % s)
+		VALUES % s),
+	_old AS (
+		SELECT
+			orders.number,
+			orders.status
+		FROM
+			orders
+			INNER JOIN _data ON _data.number = orders.number
+		ORDER BY
+			orders.number
+		FOR UPDATE OF orders),
+	_updated AS (
+		UPDATE
+			orders
+		SET
+			status = GREATEST(orders.status, CAST(_data.status AS statuses)),
+			lease_owner = CASE WHEN orders.lease_owner = $%d THEN NULL ELSE orders.lease_owner END,
+			lease_until = CASE WHEN orders.lease_owner = $%d THEN NULL ELSE orders.lease_until END
+		FROM
+			_data,
+			_old
+		WHERE
+			orders.number = _data.number
+			AND _old.number = orders.number
+			AND orders.status NOT IN ('PROCESSED', 'INVALID')
+		RETURNING
+			orders.number,
+			orders.status,
+			_old.status AS old_status)
+	INSERT INTO order_status_history (number, status, changed_at)
+	SELECT
+		number,
+		status,
+		CURRENT_TIMESTAMP
+	FROM
+		_updated
+	WHERE
+		status IS DISTINCT FROM old_status
+	RETURNING
+		number`
+	sql = fmt.Sprintf(sql, strings.Join(valueStrings, ","), len(valueArgs), len(valueArgs))
+
+	rows, err := kp.conn.QueryContext(ctx, sql, valueArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update order status: %w", err)
+	}
+
+	defer rows.Close()
+
+	changed := make([]string, 0)
+
+	for rows.Next() {
+		var number string
+
+		if err := rows.Scan(&number); err != nil {
+			return nil, fmt.Errorf("failed to update order status: %w", err)
+		}
+
+		changed = append(changed, number)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to update order status: %w", err)
+	}
+
+	return changed, nil
+}
+
+// HasOrder reports whether the order is uploaded.
+func (kp *BDKeeper) HasOrder(number string) (bool, error) {
+	ctx := context.Background()
+
+	sql := `
+	SELECT
+		EXISTS (
+			SELECT
+				1
+			FROM
+				orders
+			WHERE
+				number = $1)`
+	row := kp.conn.QueryRowContext(ctx, sql, number)
+
+	var exists bool
+	if err := row.Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check order: %w", err)
+	}
+
+	return exists, nil
+}
+
+// SetOrderPushed remembers the time of the last push update of the order.
+func (kp *BDKeeper) SetOrderPushed(number string) error {
+	ctx := context.Background()
+
+	sql := `
 	UPDATE
 		orders
 	SET
-		status = CAST(_data.status AS statuses)
-	FROM
-		_data
+		pushed_at = CURRENT_TIMESTAMP
 	WHERE
-		orders.number = _data.number`
-	sql = fmt.Sprintf(sql, strings.Join(valueStrings, ","))
+		number = $1`
 
-	_, err := kp.conn.ExecContext(ctx, sql, valueArgs...)
+	_, err := kp.conn.ExecContext(ctx, sql, number)
 	if err != nil {
-		return fmt.Errorf("failed to withdraw: %w", err)
+		return fmt.Errorf("failed to set order pushed: %w", err)
 	}
 
 	return nil
@@ -634,32 +787,135 @@ func (kp *BDKeeper) InsertAccruel(orders map[string]models.ExtRespOrder) error {
 	for _, v := range orders {
 		valueStrings = append(valueStrings, fmt.Sprintf("($%d, $%d)", i*2+1, i*2+2))
 		valueArgs = append(valueArgs, v.Order)
-		valueArgs = append(valueArgs, fmt.Sprintf("%f", v.Accrual))
+		valueArgs = append(valueArgs, v.Accrual)
 		i++
 	}
 
+	// every accrual is a journal entry moving the points from the accrual
+	// source account to the user account, an order is accrued only once,
+	// the user balance is updated in the same statement
 	sql := `
 	WITH _data (
 		number,
 		accrual
 	) AS (
-		VALUES % s)
-	INSERT INTO savings_account (user_id, processed_at, id_order_in, accrual)
+		VALUES % s),
+	_new AS (
+		SELECT
+			gen_random_uuid()::text AS entry_id,
+			orders.user_id,
+			_data.number,
+			CAST(_data.accrual AS numeric) AS accrual
+		FROM
+			_data
+			INNER JOIN orders ON _data.number = orders.number
+	),
+	_entries AS (
+		INSERT INTO journal_entries (entry_id, kind, reference, created_at)
+		SELECT
+			entry_id,
+			'accrual',
+			number,
+			CURRENT_TIMESTAMP
+		FROM
+			_new
+		ON CONFLICT
+			DO NOTHING
+		RETURNING
+			entry_id
+	),
+	_balances AS (
+		INSERT INTO balances (user_id, current, version, updated_at)
+		SELECT
+			_new.user_id,
+			SUM(_new.accrual),
+			1,
+			CURRENT_TIMESTAMP
+		FROM
+			_new
+			INNER JOIN _entries ON _entries.entry_id = _new.entry_id
+		GROUP BY
+			_new.user_id
+		ON CONFLICT (user_id)
+			DO UPDATE SET
+				current = balances.current + EXCLUDED.current,
+				version = balances.version + 1,
+				updated_at = EXCLUDED.updated_at
+	)
+	INSERT INTO postings (entry_id, account, id_order_in, amount)
 	SELECT
-		orders.user_id,
-		CURRENT_TIMESTAMP,
-		_data.number,
-		to_number(_data.accrual, '999G9999D99999999')
+		_new.entry_id,
+		_new.user_id,
+		_new.number,
+		_new.accrual
 	FROM
-		_data
-		INNER JOIN orders ON _data.number = orders.number
-		LEFT JOIN savings_account AS SA ON _data.number = SA.id_order_in
-			AND SA.id_order_out IS NULL
-	WHERE
-		SA.id_order_in IS NULL`
+		_new
+		INNER JOIN _entries ON _entries.entry_id = _new.entry_id
+	UNION ALL
+	SELECT
+		_new.entry_id,
+		'accrual_source',
+		NULL,
+		- _new.accrual
+	FROM
+		_new
+		INNER JOIN _entries ON _entries.entry_id = _new.entry_id
+	RETURNING
+		account`
 	sql = fmt.Sprintf(sql, strings.Join(valueStrings, ","))
 
-	_, err := kp.conn.ExecContext(ctx, sql, valueArgs...)
+	// start the transaction
+	tx, err := kp.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to insert accruel: %w", err)
+	}
+
+	// if the commit is unsuccessful, all changes to the transaction will be rolled back
+	defer func() {
+		if err = tx.Rollback(); err != nil {
+			return
+		}
+	}()
+
+	rows, err := tx.QueryContext(ctx, sql, valueArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to insert accruel: %w", err)
+	}
+
+	defer rows.Close()
+
+	// collect the users who got the points of the batch
+	userIDs := make([]string, 0)
+	accrued := make(map[string]bool)
+
+	for rows.Next() {
+		var account string
+
+		if err := rows.Scan(&account); err != nil {
+			return fmt.Errorf("failed to insert accruel: %w", err)
+		}
+
+		if account != accountAccrualSource && !accrued[account] {
+			accrued[account] = true
+			userIDs = append(userIDs, account)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("failed to insert accruel: %w", err)
+	}
+
+	// Закроем выборку до следующих запросов в транзакции.
+	rows.Close()
+
+	// the new points pay off the debts of the clawed back accruals first
+	err = kp.settleDebts(ctx, tx, userIDs)
+	if err != nil {
+		return fmt.Errorf("failed to insert accruel: %w", err)
+	}
+
+	// commit the transaction
+	err = tx.Commit()
 	if err != nil {
 		return fmt.Errorf("failed to insert accruel: %w", err)
 	}
@@ -668,7 +924,7 @@ func (kp *BDKeeper) InsertAccruel(orders map[string]models.ExtRespOrder) error {
 }
 
 func (kp *BDKeeper) Ping() bool {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Microsecond)
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
 	defer cancel()
 
 	if err := kp.conn.PingContext(ctx); err != nil {
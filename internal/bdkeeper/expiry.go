@@ -0,0 +1,188 @@
+package bdkeeper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/wurt83ow/gophermart/internal/models"
+	"go.uber.org/zap"
+)
+
+// GetExpiringPoints returns the unspent points of the user accrued
+// earlier than the age ago by the database clock.
+// Like the expiry itself, it does not exceed the available balance of the user,
+// so a negative balance has no expiring points.
+func (kp *BDKeeper) GetExpiringPoints(userID string, age time.Duration) (models.Money, error) {
+	ctx := context.Background()
+
+	sql := `
+	SELECT
+		GREATEST(LEAST(COALESCE(SUM(lots.accrual), 0), COALESCE((
+			SELECT
+				current - held
+			FROM
+				balances
+			WHERE
+				user_id = $1), 0)), 0)
+	FROM (
+		SELECT
+			SUM(p.amount) AS accrual
+		FROM
+			postings AS p
+			INNER JOIN journal_entries AS a ON a.kind = 'accrual'
+				AND a.reference = p.id_order_in
+		WHERE
+			p.account = $1
+			AND a.created_at <= CURRENT_TIMESTAMP - $2 * INTERVAL '1 millisecond'
+		GROUP BY
+			p.id_order_in
+		HAVING
+			SUM(p.amount) > 0) AS lots`
+	row := kp.conn.QueryRowContext(ctx, sql, userID, age.Milliseconds())
+
+	var m models.Money
+	if err := row.Scan(&m); err != nil {
+		return 0, fmt.Errorf("failed to get expiring points: %w", err)
+	}
+
+	return m, nil
+}
+
+// ExpirePoints writes off the unspent points of the lots accrued earlier than
+// the age ago by the database clock, it returns the sum of the expired points.
+func (kp *BDKeeper) ExpirePoints(age time.Duration) (models.Money, error) {
+	ctx := context.Background()
+
+	sql := `
+	SELECT
+		p.account,
+		p.id_order_in
+	FROM
+		postings AS p
+		INNER JOIN journal_entries AS a ON a.kind = 'accrual'
+			AND a.reference = p.id_order_in
+	WHERE
+		a.created_at <= CURRENT_TIMESTAMP - $1 * INTERVAL '1 millisecond'
+		AND p.account NOT IN ('accrual_source', 'redemption_sink', 'accrual_debt', 'expired_points')
+	GROUP BY
+		p.account,
+		p.id_order_in
+	HAVING
+		SUM(p.amount) > 0`
+
+	rows, err := kp.conn.QueryContext(ctx, sql, age.Milliseconds())
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire points: %w", err)
+	}
+
+	defer rows.Close()
+
+	lots := make([]posting, 0)
+
+	for rows.Next() {
+		var p posting
+
+		if err := rows.Scan(&p.account, &p.lot); err != nil {
+			return 0, fmt.Errorf("failed to expire points: %w", err)
+		}
+
+		lots = append(lots, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to expire points: %w", err)
+	}
+
+	// Закроем выборку до списания баллов.
+	rows.Close()
+
+	var total models.Money
+
+	for _, lot := range lots {
+		var expired models.Money
+
+		err := kp.retryOnBalanceChange(zap.String("user", lot.account), func() error {
+			var err error
+			expired, err = kp.expireLot(ctx, lot.account, lot.lot)
+
+			return err
+		})
+		if err != nil && !errors.Is(err, errBalanceChanged) {
+			return total, fmt.Errorf("failed to expire points: %w", err)
+		}
+
+		// the lot changed concurrently is expired by the next run
+		total += expired
+	}
+
+	return total, nil
+}
+
+// expireLot writes off the rest of the lot, but not more than the available balance.
+func (kp *BDKeeper) expireLot(ctx context.Context, userID string, lot string) (models.Money, error) {
+	// start the transaction
+	tx, err := kp.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire lot: %w", err)
+	}
+
+	// if the commit is unsuccessful, all changes to the transaction will be rolled back
+	defer func() {
+		if err = tx.Rollback(); err != nil {
+			return
+		}
+	}()
+
+	balance, version, err := kp.getBalance(ctx, tx, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire lot: %w", err)
+	}
+
+	sql := `
+	SELECT
+		COALESCE(SUM(amount), 0)
+	FROM
+		postings
+	WHERE
+		account = $1
+		AND id_order_in = $2`
+	row := tx.QueryRowContext(ctx, sql, userID, lot)
+
+	var expired models.Money
+	if err = row.Scan(&expired); err != nil {
+		return 0, fmt.Errorf("failed to expire lot: %w", err)
+	}
+
+	// the points owed by a negative balance are already spent,
+	// the held points expire after the hold is released
+	if balance.Current-balance.Held < expired {
+		expired = balance.Current - balance.Held
+	}
+
+	if expired <= 0 {
+		return 0, nil
+	}
+
+	err = kp.postEntry(ctx, tx, entryExpiry, lot, []posting{
+		{account: userID, lot: lot, amount: -expired},
+		{account: accountExpiredPoints, amount: expired},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire lot: %w", err)
+	}
+
+	_, err = kp.updateBalance(ctx, tx, userID, models.DataBalance{Current: -expired}, version)
+	if err != nil {
+		return 0, err
+	}
+
+	// commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire lot: %w", err)
+	}
+
+	return expired, nil
+}
@@ -0,0 +1,357 @@
+package bdkeeper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/wurt83ow/gophermart/internal/models"
+	"github.com/wurt83ow/gophermart/internal/storage"
+	"go.uber.org/zap"
+)
+
+// CreateHold reserves the points of the user for the order till the timeout,
+// the held points are not available until the hold is finished.
+func (kp *BDKeeper) CreateHold(hold models.DataHold, timeout time.Duration) (models.DataHold, error) {
+	ctx := context.Background()
+
+	hold.ID = uuid.New().String()
+	hold.Status = models.HoldHeld
+
+	err := kp.retryOnBalanceChange(zap.String("user", hold.UserID), func() error {
+		var err error
+		hold.ExpiresAt, err = kp.createHold(ctx, hold, timeout)
+
+		return err
+	})
+	if errors.Is(err, errBalanceChanged) {
+		return models.DataHold{}, fmt.Errorf("failed to create hold: %w", err)
+	}
+
+	if err != nil {
+		return models.DataHold{}, err
+	}
+
+	hold.ExpiresRFC = hold.ExpiresAt.Format(time.RFC3339)
+
+	return hold, nil
+}
+
+func (kp *BDKeeper) createHold(ctx context.Context, hold models.DataHold,
+	timeout time.Duration,
+) (time.Time, error) {
+	// start the transaction
+	tx, err := kp.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to create hold: %w", err)
+	}
+
+	// if the commit is unsuccessful, all changes to the transaction will be rolled back
+	defer func() {
+		if err = tx.Rollback(); err != nil {
+			return
+		}
+	}()
+
+	// the points of an order which is already withdrawn can not be held
+	sql := `
+	SELECT
+		EXISTS (
+			SELECT
+				1
+			FROM
+				withdrawals
+			WHERE
+				user_id = $1
+				AND number = $2)`
+	row := tx.QueryRowContext(ctx, sql, hold.UserID, hold.Order)
+
+	var withdrawn bool
+	if err = row.Scan(&withdrawn); err != nil {
+		return time.Time{}, fmt.Errorf("failed to create hold: %w", err)
+	}
+
+	if withdrawn {
+		return time.Time{}, storage.ErrConflict
+	}
+
+	balance, version, err := kp.getBalance(ctx, tx, hold.UserID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to create hold: %w", err)
+	}
+
+	if balance.Current-balance.Held < hold.Sum {
+		return time.Time{}, storage.ErrInsufficient
+	}
+
+	sql = `
+	INSERT INTO holds (hold_id, user_id, number, sum, status, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP,
+			CURRENT_TIMESTAMP + $6 * interval '1 millisecond')
+	RETURNING
+		expires_at`
+	row = tx.QueryRowContext(ctx, sql, hold.ID, hold.UserID, hold.Order,
+		hold.Sum, hold.Status, timeout.Milliseconds())
+
+	var expiresAt time.Time
+
+	err = row.Scan(&expiresAt)
+	if err != nil {
+		var e *pgconn.PgError
+		if errors.As(err, &e) && e.Code == pgerrcode.UniqueViolation {
+			return expiresAt, storage.ErrConflict
+		}
+
+		return expiresAt, fmt.Errorf("failed to create hold: %w", err)
+	}
+
+	_, err = kp.updateBalance(ctx, tx, hold.UserID, models.DataBalance{Held: hold.Sum}, version)
+	if err != nil {
+		return expiresAt, err
+	}
+
+	// commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return expiresAt, fmt.Errorf("failed to create hold: %w", err)
+	}
+
+	return expiresAt, nil
+}
+
+// CaptureHold withdraws the held points for the order of the hold.
+func (kp *BDKeeper) CaptureHold(userID string, holdID string) (models.DataHold, error) {
+	return kp.finishHold(userID, holdID, models.HoldCaptured)
+}
+
+// ReleaseHold returns the held points to the available balance.
+func (kp *BDKeeper) ReleaseHold(userID string, holdID string) (models.DataHold, error) {
+	return kp.finishHold(userID, holdID, models.HoldReleased)
+}
+
+// ExpireHolds releases the expired holds, it returns the number of the expired holds.
+func (kp *BDKeeper) ExpireHolds() (int, error) {
+	ctx := context.Background()
+
+	sql := `
+	SELECT
+		user_id,
+		hold_id
+	FROM
+		holds
+	WHERE
+		status = 'HELD'
+		AND expires_at <= CURRENT_TIMESTAMP`
+
+	rows, err := kp.conn.QueryContext(ctx, sql)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire holds: %w", err)
+	}
+
+	defer rows.Close()
+
+	holds := make([]models.DataHold, 0)
+
+	for rows.Next() {
+		var m models.DataHold
+
+		if err := rows.Scan(&m.UserID, &m.ID); err != nil {
+			return 0, fmt.Errorf("failed to expire holds: %w", err)
+		}
+
+		holds = append(holds, m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to expire holds: %w", err)
+	}
+
+	// Закроем выборку до снятия холдов.
+	rows.Close()
+
+	expired := 0
+
+	for _, h := range holds {
+		_, err := kp.finishHold(h.UserID, h.ID, models.HoldExpired)
+		if errors.Is(err, storage.ErrConflict) {
+			// the hold is finished concurrently
+			continue
+		}
+
+		if err != nil {
+			// the failed hold is expired by the next run,
+			// it must not keep the others held
+			kp.log.Info("failed to expire hold: ", zap.String("hold", h.ID),
+				zap.String("user", h.UserID), zap.Error(err))
+
+			continue
+		}
+
+		expired++
+	}
+
+	return expired, nil
+}
+
+// finishHold moves the hold to the final status. A hold finished with the same
+// status is returned as is, a hold finished otherwise is a conflict.
+func (kp *BDKeeper) finishHold(userID string, holdID string, status string) (models.DataHold, error) {
+	ctx := context.Background()
+
+	var hold models.DataHold
+
+	err := kp.retryOnBalanceChange(zap.String("user", userID), func() error {
+		var err error
+		hold, err = kp.finish(ctx, userID, holdID, status)
+
+		return err
+	})
+	if errors.Is(err, errBalanceChanged) {
+		return models.DataHold{}, fmt.Errorf("failed to finish hold: %w", err)
+	}
+
+	if err != nil {
+		return hold, err
+	}
+
+	hold.ExpiresRFC = hold.ExpiresAt.Format(time.RFC3339)
+
+	return hold, nil
+}
+
+func (kp *BDKeeper) finish(ctx context.Context, userID string, holdID string,
+	status string,
+) (models.DataHold, error) {
+	// start the transaction
+	tx, err := kp.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return models.DataHold{}, fmt.Errorf("failed to finish hold: %w", err)
+	}
+
+	// if the commit is unsuccessful, all changes to the transaction will be rolled back
+	defer func() {
+		if err = tx.Rollback(); err != nil {
+			return
+		}
+	}()
+
+	// lock the hold until it is finished
+	sql := `
+	SELECT
+		hold_id,
+		user_id,
+		number,
+		sum,
+		status,
+		expires_at,
+		expires_at <= CURRENT_TIMESTAMP AS expired
+	FROM
+		holds
+	WHERE
+		hold_id = $1
+		AND user_id = $2
+	FOR UPDATE`
+
+	rows, err := tx.QueryContext(ctx, sql, holdID, userID)
+	if err != nil {
+		return models.DataHold{}, fmt.Errorf("failed to finish hold: %w", err)
+	}
+
+	defer rows.Close()
+
+	var (
+		m       models.DataHold
+		found   bool
+		expired bool
+	)
+
+	for rows.Next() {
+		err = rows.Scan(&m.ID, &m.UserID, &m.Order, &m.Sum, &m.Status, &m.ExpiresAt, &expired)
+		if err != nil {
+			return models.DataHold{}, fmt.Errorf("failed to finish hold: %w", err)
+		}
+
+		found = true
+	}
+
+	if err = rows.Err(); err != nil {
+		return models.DataHold{}, fmt.Errorf("failed to finish hold: %w", err)
+	}
+
+	// Закроем выборку до следующих запросов в транзакции.
+	rows.Close()
+
+	if !found {
+		return models.DataHold{}, storage.ErrNotFound
+	}
+
+	if m.Status == status {
+		return m, nil
+	}
+
+	if m.Status != models.HoldHeld {
+		return m, storage.ErrConflict
+	}
+
+	// an expired hold can not be captured any more
+	if status == models.HoldCaptured && expired {
+		return m, storage.ErrExpired
+	}
+
+	_, version, err := kp.getBalance(ctx, tx, userID)
+	if err != nil {
+		return m, fmt.Errorf("failed to finish hold: %w", err)
+	}
+
+	if status == models.HoldCaptured {
+		withdraw := models.DataWithdraw{UserID: userID, Order: m.Order, Sum: m.Sum}
+
+		replay, err := kp.registerWithdraw(ctx, tx, withdraw)
+		if err != nil {
+			return m, err
+		}
+
+		if replay {
+			return m, storage.ErrConflict
+		}
+
+		err = kp.writeWithdrawal(ctx, tx, withdraw, version, m.Sum)
+		if err != nil {
+			return m, err
+		}
+	} else {
+		_, err = kp.updateBalance(ctx, tx, userID, models.DataBalance{Held: -m.Sum}, version)
+		if err != nil {
+			return m, err
+		}
+	}
+
+	sql = `
+	UPDATE
+		holds
+	SET
+		status = $2
+	WHERE
+		hold_id = $1`
+
+	_, err = tx.ExecContext(ctx, sql, holdID, status)
+	if err != nil {
+		return m, fmt.Errorf("failed to finish hold: %w", err)
+	}
+
+	// commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return m, fmt.Errorf("failed to finish hold: %w", err)
+	}
+
+	m.Status = status
+
+	kp.log.Info("hold finished: ", zap.String("hold", holdID), zap.String("status", status))
+
+	return m, nil
+}
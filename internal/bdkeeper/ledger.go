@@ -0,0 +1,300 @@
+package bdkeeper
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/wurt83ow/gophermart/internal/models"
+	"github.com/wurt83ow/gophermart/internal/storage"
+	"go.uber.org/zap"
+)
+
+// system accounts of the ledger, user accounts are named by user id.
+const (
+	accountAccrualSource  = "accrual_source"
+	accountRedemptionSink = "redemption_sink"
+	accountAccrualDebt    = "accrual_debt"
+	accountExpiredPoints  = "expired_points"
+)
+
+// maxBalanceAttempts limits the operations repeated on a concurrently changed balance.
+const maxBalanceAttempts = 3
+
+// errBalanceChanged indicates the balance version has changed since it was read.
+var errBalanceChanged = errors.New("balance changed concurrently")
+
+// kinds of journal entries.
+const (
+	entryAccrual        = "accrual"
+	entryWithdrawal     = "withdrawal"
+	entryReversal       = "reversal"
+	entryDebtSettlement = "debt_settlement"
+	entryExpiry         = "expiry"
+	entryTransfer       = "transfer"
+)
+
+// posting moves the amount to the account, lot is the accrual order
+// the points of a user account come from.
+type posting struct {
+	account string
+	lot     string
+	amount  models.Money
+}
+
+// postEntry writes a balanced journal entry with its postings in the transaction.
+// The entry is dated by the database clock like the accruals and the holds,
+// so the entries of all kinds are ordered by the same clock. The clock time
+// orders the entries of one transaction too: a debt settlement follows
+// the accrual of the same transaction, which is dated by its start.
+func (kp *BDKeeper) postEntry(ctx context.Context, tx *sql.Tx, kind string,
+	reference string, postings []posting,
+) error {
+	var total models.Money
+	for _, p := range postings {
+		total += p.amount
+	}
+
+	if total != 0 {
+		return fmt.Errorf("failed to post %s entry: postings are not balanced by %s", kind, total)
+	}
+
+	entryID := uuid.New().String()
+
+	sql := `
+	INSERT INTO journal_entries (entry_id, kind, reference, created_at)
+		VALUES ($1, $2, $3, clock_timestamp())`
+
+	var ref interface{}
+	if reference != "" {
+		ref = reference
+	}
+
+	_, err := tx.ExecContext(ctx, sql, entryID, kind, ref)
+	if err != nil {
+		return fmt.Errorf("failed to post %s entry: %w", kind, err)
+	}
+
+	valueStrings := make([]string, 0, len(postings))
+	valueArgs := make([]interface{}, 0, len(postings)*4)
+
+	for i, p := range postings {
+		valueStrings = append(valueStrings,
+			fmt.Sprintf("($%d, $%d, $%d, $%d)", i*4+1, i*4+2, i*4+3, i*4+4))
+
+		var lot interface{}
+		if p.lot != "" {
+			lot = p.lot
+		}
+
+		valueArgs = append(valueArgs, entryID, p.account, lot, p.amount)
+	}
+
+	sql = `
+	INSERT INTO postings (entry_id, account, id_order_in, amount)
+		VALUES %s`
+	sql = fmt.Sprintf(sql, strings.Join(valueStrings, ","))
+
+	_, err = tx.ExecContext(ctx, sql, valueArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to post %s entry: %w", kind, err)
+	}
+
+	return nil
+}
+
+// writeWithdrawal spends the withdrawal sum from the lots of the user, the held sum
+// is released at the same time. The balance must still have the read version.
+func (kp *BDKeeper) writeWithdrawal(ctx context.Context, tx *sql.Tx,
+	withdraw models.DataWithdraw, version int64, held models.Money,
+) error {
+	// Спишем баллы с заказов начисления, начиная с самого старого.
+	postings, leftWrite, err := kp.takeLots(ctx, tx, withdraw.UserID, withdraw.Sum, "")
+	if err != nil {
+		return fmt.Errorf("failed to withdraw: %w", err)
+	}
+
+	// Остатков по заказам не хватило, значит остаток был изменен
+	// параллельной транзакцией.
+	if leftWrite > 0 {
+		return errBalanceChanged
+	}
+
+	// Запишем проводку списания: баллы со счета покупателя
+	// переходят на системный счет погашения.
+	postings = append(postings, posting{account: accountRedemptionSink, amount: withdraw.Sum})
+
+	err = kp.postEntry(ctx, tx, entryWithdrawal, withdraw.Order, postings)
+	if err != nil {
+		return fmt.Errorf("failed to withdraw: %w", err)
+	}
+
+	// Спишем баллы с остатка, только если его версия не изменилась с момента
+	// чтения. Блокируется единственная строка остатка покупателя.
+	balance, err := kp.updateBalance(ctx, tx, withdraw.UserID, models.DataBalance{
+		Current:   -withdraw.Sum,
+		Withdrawn: withdraw.Sum,
+		Held:      -held,
+	}, version)
+	if err != nil {
+		return err
+	}
+
+	if balance.Current < balance.Held {
+		return storage.ErrInsufficient
+	}
+
+	return nil
+}
+
+// getBalance reads the user balance with its version in the transaction.
+func (kp *BDKeeper) getBalance(ctx context.Context, tx *sql.Tx, userID string) (models.DataBalance, int64, error) {
+	sql := `
+	SELECT
+		COALESCE(MAX(current), 0) AS current,
+		COALESCE(MAX(withdrawn), 0) AS withdrawn,
+		COALESCE(MAX(debt), 0) AS debt,
+		COALESCE(MAX(held), 0) AS held,
+		COALESCE(MAX(version), 0) AS version
+	FROM
+		balances
+	WHERE
+		user_id = $1`
+	row := tx.QueryRowContext(ctx, sql, userID)
+
+	var (
+		m       models.DataBalance
+		version int64
+	)
+
+	if err := row.Scan(&m.Current, &m.Withdrawn, &m.Debt, &m.Held, &version); err != nil {
+		return models.DataBalance{}, 0, fmt.Errorf("failed to get balance: %w", err)
+	}
+
+	return m, version, nil
+}
+
+// updateBalance adds the changes to the user balance if its version is still
+// the one read before, otherwise it returns errBalanceChanged.
+func (kp *BDKeeper) updateBalance(ctx context.Context, tx *sql.Tx, userID string,
+	change models.DataBalance, version int64,
+) (models.DataBalance, error) {
+	query := `
+	INSERT INTO balances (user_id, current, withdrawn, debt, held, version, updated_at)
+		VALUES ($1, $2, $3, $4, $5, 1, CURRENT_TIMESTAMP)
+	ON CONFLICT (user_id)
+		DO UPDATE SET
+			current = balances.current + EXCLUDED.current,
+			withdrawn = balances.withdrawn + EXCLUDED.withdrawn,
+			debt = balances.debt + EXCLUDED.debt,
+			held = balances.held + EXCLUDED.held,
+			version = balances.version + 1,
+			updated_at = EXCLUDED.updated_at
+		WHERE
+			balances.version = $6
+	RETURNING
+		current,
+		withdrawn,
+		debt,
+		held`
+	row := tx.QueryRowContext(ctx, query, userID,
+		change.Current, change.Withdrawn, change.Debt, change.Held, version)
+
+	var m models.DataBalance
+
+	err := row.Scan(&m.Current, &m.Withdrawn, &m.Debt, &m.Held)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.DataBalance{}, errBalanceChanged
+	}
+
+	if err != nil {
+		return models.DataBalance{}, fmt.Errorf("failed to update balance: %w", err)
+	}
+
+	return m, nil
+}
+
+// retryOnBalanceChange repeats the operation while the balance it has read
+// is changed by a concurrent transaction, the subject identifies it in the log.
+func (kp *BDKeeper) retryOnBalanceChange(subject zap.Field, operation func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := operation()
+		if !errors.Is(err, errBalanceChanged) || attempt == maxBalanceAttempts {
+			return err
+		}
+
+		kp.log.Info("balance changed concurrently, retrying: ",
+			subject, zap.Int("attempt", attempt))
+	}
+}
+
+// takeLots spends the sum from the accrual lots of the user, the first lot
+// is spent before the others, which are spent from the oldest accrual.
+// It returns the postings and the sum the lots are short of.
+func (kp *BDKeeper) takeLots(ctx context.Context, tx *sql.Tx, userID string,
+	sum models.Money, first string,
+) ([]posting, models.Money, error) {
+	// Получим остатки баллов покупателя в разрезе заказов начисления,
+	// упорядоченные по дате начисления, чтобы первыми тратились баллы,
+	// срок действия которых истекает раньше.
+	sql := `
+	SELECT
+		p.id_order_in AS number,
+		SUM(p.amount) AS accrual
+	FROM
+		postings AS p
+		INNER JOIN journal_entries AS a ON a.kind = 'accrual'
+			AND a.reference = p.id_order_in
+	WHERE
+		p.account = $1
+	GROUP BY
+		p.id_order_in,
+		a.created_at
+	HAVING
+		SUM(p.amount) > 0
+	ORDER BY
+		p.id_order_in = $2 DESC,
+		a.created_at ASC`
+
+	rows, err := tx.QueryContext(ctx, sql, userID, first)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to take lots: %w", err)
+	}
+
+	defer rows.Close()
+
+	postings := make([]posting, 0)
+	leftWrite := sum
+
+	for rows.Next() {
+		if leftWrite <= 0 {
+			break
+		}
+
+		var m models.DataOrder
+
+		if err := rows.Scan(&m.Number, &m.Accrual); err != nil {
+			return nil, 0, fmt.Errorf("failed to take lots: %w", err)
+		}
+
+		// Создадим проводку с минусом для каждой строки заказа
+		// и вычтем сумму списания из "ОсталосьСписать"
+		accrual := leftWrite
+		if m.Accrual < accrual {
+			accrual = m.Accrual
+		}
+
+		leftWrite -= accrual
+
+		postings = append(postings, posting{account: userID, lot: m.Number, amount: -accrual})
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to take lots: %w", err)
+	}
+
+	return postings, leftWrite, nil
+}
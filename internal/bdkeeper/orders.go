@@ -0,0 +1,225 @@
+package bdkeeper
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wurt83ow/gophermart/internal/models"
+	"github.com/wurt83ow/gophermart/internal/storage"
+)
+
+// GetUserOrders returns a page of the user orders from the newest one,
+// the orders are read by the user and date index.
+func (kp *BDKeeper) GetUserOrders(userID string, filter models.OrderFilter) ([]models.DataOrder, error) {
+	ctx := context.Background()
+
+	conds := []string{"o.user_id = $1"}
+	args := []interface{}{userID}
+
+	if !filter.From.IsZero() {
+		args = append(args, filter.From)
+		conds = append(conds, fmt.Sprintf("o.date >= $%d", len(args)))
+	}
+
+	if !filter.To.IsZero() {
+		args = append(args, filter.To)
+		conds = append(conds, fmt.Sprintf("o.date < $%d", len(args)))
+	}
+
+	if len(filter.Statuses) != 0 {
+		statuses := make([]string, 0, len(filter.Statuses))
+		for _, s := range filter.Statuses {
+			args = append(args, s)
+			statuses = append(statuses, fmt.Sprintf("$%d", len(args)))
+		}
+
+		conds = append(conds, fmt.Sprintf("o.status IN (%s)", strings.Join(statuses, ", ")))
+	}
+
+	if filter.CursorNumber != "" {
+		args = append(args, filter.CursorDate, filter.CursorNumber)
+		conds = append(conds, fmt.Sprintf("(o.date, o.number) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	// a zero limit lists all the orders
+	limit := ""
+	if filter.Limit != 0 {
+		args = append(args, filter.Limit)
+		limit = fmt.Sprintf("LIMIT $%d", len(args))
+	}
+
+	// the accrual of an order is the user posting of its accrual entry
+	sql := `
+	SELECT
+		o.order_id,
+		o.number,
+		o.status,
+		o.date,
+		COALESCE(p.amount, 0) AS accrual,
+		o.user_id
+	FROM
+		orders AS o
+		LEFT JOIN journal_entries AS a ON a.kind = 'accrual'
+			AND a.reference = o.number
+		LEFT JOIN postings AS p ON p.entry_id = a.entry_id
+			AND p.account = o.user_id
+	WHERE
+		%s
+	ORDER BY
+		o.date DESC,
+		o.number DESC
+	%s`
+	sql = fmt.Sprintf(sql, strings.Join(conds, "\n\t\tAND "), limit)
+
+	rows, err := kp.conn.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user orders by userID: %w", err)
+	}
+
+	defer rows.Close()
+
+	result := make([]models.DataOrder, 0)
+
+	for rows.Next() {
+		var m models.DataOrder
+
+		err := rows.Scan(&m.UUID, &m.Number, &m.Status, &m.Date, &m.Accrual, &m.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user orders by userID: %w", err)
+		}
+
+		m.DateRFC = m.Date.Format(time.RFC3339)
+		result = append(result, m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get user orders by userID: %w", err)
+	}
+
+	return result, nil
+}
+
+// GetUserOrder returns the order of the user with its status history
+// and the withdrawals which spent the points accrued for it.
+func (kp *BDKeeper) GetUserOrder(userID string, number string) (models.DataOrderDetail, error) {
+	ctx := context.Background()
+
+	query := `
+	SELECT
+		o.order_id,
+		o.number,
+		o.status,
+		o.date,
+		COALESCE(p.amount, 0) AS accrual,
+		o.user_id
+	FROM
+		orders AS o
+		LEFT JOIN journal_entries AS a ON a.kind = 'accrual'
+			AND a.reference = o.number
+		LEFT JOIN postings AS p ON p.entry_id = a.entry_id
+			AND p.account = o.user_id
+	WHERE
+		o.user_id = $1
+		AND o.number = $2`
+	row := kp.conn.QueryRowContext(ctx, query, userID, number)
+
+	m := models.DataOrderDetail{
+		History:     make([]models.DataOrderStatus, 0),
+		Withdrawals: make([]models.DataWithdraw, 0),
+	}
+
+	err := row.Scan(&m.UUID, &m.Number, &m.Status, &m.Date, &m.Accrual, &m.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return m, storage.ErrNotFound
+	}
+
+	if err != nil {
+		return m, fmt.Errorf("failed to get user order: %w", err)
+	}
+
+	m.DateRFC = m.Date.Format(time.RFC3339)
+
+	sql := `
+	SELECT
+		status,
+		changed_at
+	FROM
+		order_status_history
+	WHERE
+		number = $1
+	ORDER BY
+		changed_at`
+
+	rows, err := kp.conn.QueryContext(ctx, sql, number)
+	if err != nil {
+		return m, fmt.Errorf("failed to get user order: %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var h models.DataOrderStatus
+
+		if err := rows.Scan(&h.Status, &h.Date); err != nil {
+			return m, fmt.Errorf("failed to get user order: %w", err)
+		}
+
+		h.DateRFC = h.Date.Format(time.RFC3339)
+		m.History = append(m.History, h)
+	}
+
+	if err = rows.Err(); err != nil {
+		return m, fmt.Errorf("failed to get user order: %w", err)
+	}
+
+	// Закроем выборку до следующего запроса.
+	rows.Close()
+
+	// the withdrawals spent the points of the order lot
+	sql = `
+	SELECT
+		e.reference AS order,
+		- SUM(p.amount) AS sum,
+		e.created_at AS date
+	FROM
+		postings AS p
+		INNER JOIN journal_entries AS e ON e.entry_id = p.entry_id
+	WHERE
+		p.account = $1
+		AND p.id_order_in = $2
+		AND e.kind = 'withdrawal'
+	GROUP BY
+		e.entry_id,
+		e.reference,
+		e.created_at
+	ORDER BY
+		e.created_at`
+
+	rows, err = kp.conn.QueryContext(ctx, sql, userID, number)
+	if err != nil {
+		return m, fmt.Errorf("failed to get user order: %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var w models.DataWithdraw
+
+		if err := rows.Scan(&w.Order, &w.Sum, &w.Date); err != nil {
+			return m, fmt.Errorf("failed to get user order: %w", err)
+		}
+
+		w.DateRFC = w.Date.Format(time.RFC3339)
+		m.Withdrawals = append(m.Withdrawals, w)
+	}
+
+	if err = rows.Err(); err != nil {
+		return m, fmt.Errorf("failed to get user order: %w", err)
+	}
+
+	return m, nil
+}
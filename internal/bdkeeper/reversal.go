@@ -0,0 +1,262 @@
+package bdkeeper
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/wurt83ow/gophermart/internal/models"
+	"github.com/wurt83ow/gophermart/internal/storage"
+	"go.uber.org/zap"
+)
+
+// ReverseAccrual reverses the accrual of the cancelled order with a compensating
+// entry. The policy decides what happens when the user has already spent the points.
+func (kp *BDKeeper) ReverseAccrual(number string, policy string) (models.DataReversal, error) {
+	ctx := context.Background()
+
+	var (
+		result models.DataReversal
+		userID string
+	)
+
+	err := kp.retryOnBalanceChange(zap.String("order", number), func() error {
+		var err error
+		result, userID, err = kp.reverseAccrual(ctx, number, policy)
+
+		return err
+	})
+	if errors.Is(err, errBalanceChanged) {
+		return models.DataReversal{}, fmt.Errorf("failed to reverse accrual: %w", err)
+	}
+
+	if err != nil {
+		return models.DataReversal{}, err
+	}
+
+	kp.log.Info("accrual reversed: ", zap.String("order", number), zap.String("user", userID),
+		zap.String("policy", policy), zap.String("debt", result.Debt.String()))
+
+	return result, nil
+}
+
+func (kp *BDKeeper) reverseAccrual(ctx context.Context, number string,
+	policy string,
+) (models.DataReversal, string, error) {
+	result := models.DataReversal{Order: number, Policy: policy}
+
+	// start the transaction
+	tx, err := kp.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return result, "", fmt.Errorf("failed to reverse accrual: %w", err)
+	}
+
+	// if the commit is unsuccessful, all changes to the transaction will be rolled back
+	defer func() {
+		if err = tx.Rollback(); err != nil {
+			return
+		}
+	}()
+
+	// find the accrual entry of the order and check it is not reversed yet
+	query := `
+	SELECT
+		p.account,
+		p.amount,
+		EXISTS (
+			SELECT
+				1
+			FROM
+				journal_entries
+			WHERE
+				kind = 'reversal'
+				AND reference = $1) AS reversed
+	FROM
+		journal_entries AS e
+		INNER JOIN postings AS p ON p.entry_id = e.entry_id
+	WHERE
+		e.kind = 'accrual'
+		AND e.reference = $1
+		AND p.account <> 'accrual_source'`
+	row := tx.QueryRowContext(ctx, query, number)
+
+	var (
+		userID   string
+		reversed bool
+	)
+
+	err = row.Scan(&userID, &result.Accrual, &reversed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return result, "", storage.ErrNotFound
+	}
+
+	if err != nil {
+		return result, "", fmt.Errorf("failed to reverse accrual: %w", err)
+	}
+
+	if reversed {
+		return result, userID, storage.ErrConflict
+	}
+
+	balance, version, err := kp.getBalance(ctx, tx, userID)
+	if err != nil {
+		return result, userID, fmt.Errorf("failed to reverse accrual: %w", err)
+	}
+
+	postings := []posting{{account: accountAccrualSource, amount: result.Accrual}}
+
+	switch policy {
+	case models.ReversalNegative:
+		// the whole accrual leaves the order lot, the balance may become negative
+		result.Reversed = result.Accrual
+		postings = append(postings, posting{account: userID, lot: number, amount: -result.Accrual})
+	case models.ReversalClawback:
+		// take back what is available, first from the order lot, the rest is owed
+		result.Reversed = result.Accrual
+		if balance.Current-balance.Held < result.Reversed {
+			result.Reversed = balance.Current - balance.Held
+		}
+
+		if result.Reversed < 0 {
+			result.Reversed = 0
+		}
+
+		lots, left, err := kp.takeLots(ctx, tx, userID, result.Reversed, number)
+		if err != nil {
+			return result, userID, fmt.Errorf("failed to reverse accrual: %w", err)
+		}
+
+		if left > 0 {
+			return result, userID, errBalanceChanged
+		}
+
+		result.Debt = result.Accrual - result.Reversed
+		postings = append(postings, lots...)
+
+		if result.Debt > 0 {
+			postings = append(postings, posting{account: accountAccrualDebt, lot: number, amount: -result.Debt})
+		}
+	default:
+		return result, userID, fmt.Errorf("failed to reverse accrual: unknown policy %q", policy)
+	}
+
+	err = kp.postEntry(ctx, tx, entryReversal, number, postings)
+	if err != nil {
+		var e *pgconn.PgError
+		if errors.As(err, &e) && e.Code == pgerrcode.UniqueViolation {
+			return result, userID, storage.ErrConflict
+		}
+
+		return result, userID, fmt.Errorf("failed to reverse accrual: %w", err)
+	}
+
+	_, err = kp.updateBalance(ctx, tx, userID,
+		models.DataBalance{Current: -result.Reversed, Debt: result.Debt}, version)
+	if err != nil {
+		return result, userID, err
+	}
+
+	// commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return result, userID, fmt.Errorf("failed to reverse accrual: %w", err)
+	}
+
+	return result, userID, nil
+}
+
+// settleDebts pays off the debts of the clawed back accruals
+// from the points available on the balances of the users.
+func (kp *BDKeeper) settleDebts(ctx context.Context, tx *sql.Tx, userIDs []string) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	valueStrings := make([]string, 0, len(userIDs))
+	valueArgs := make([]interface{}, 0, len(userIDs))
+
+	for i, id := range userIDs {
+		valueStrings = append(valueStrings, fmt.Sprintf("$%d", i+1))
+		valueArgs = append(valueArgs, id)
+	}
+
+	sql := `
+	SELECT
+		user_id,
+		LEAST(current - held, debt) AS debt,
+		version
+	FROM
+		balances
+	WHERE
+		user_id IN (%s)
+		AND debt > 0
+		AND current - held > 0
+	ORDER BY
+		user_id
+	FOR UPDATE`
+	sql = fmt.Sprintf(sql, strings.Join(valueStrings, ", "))
+
+	rows, err := tx.QueryContext(ctx, sql, valueArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to settle debts: %w", err)
+	}
+
+	defer rows.Close()
+
+	type debt struct {
+		userID  string
+		sum     models.Money
+		version int64
+	}
+
+	debts := make([]debt, 0)
+
+	for rows.Next() {
+		var d debt
+
+		if err := rows.Scan(&d.userID, &d.sum, &d.version); err != nil {
+			return fmt.Errorf("failed to settle debts: %w", err)
+		}
+
+		debts = append(debts, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("failed to settle debts: %w", err)
+	}
+
+	// Закроем выборку до следующих запросов в транзакции.
+	rows.Close()
+
+	for _, d := range debts {
+		postings, left, err := kp.takeLots(ctx, tx, d.userID, d.sum, "")
+		if err != nil {
+			return fmt.Errorf("failed to settle debts: %w", err)
+		}
+
+		settled := d.sum - left
+		if settled <= 0 {
+			continue
+		}
+
+		postings = append(postings, posting{account: accountAccrualDebt, amount: settled})
+
+		err = kp.postEntry(ctx, tx, entryDebtSettlement, "", postings)
+		if err != nil {
+			return fmt.Errorf("failed to settle debts: %w", err)
+		}
+
+		// the balance rows are locked, so their versions can not change
+		_, err = kp.updateBalance(ctx, tx, d.userID,
+			models.DataBalance{Current: -settled, Debt: -settled}, d.version)
+		if err != nil {
+			return fmt.Errorf("failed to settle debts: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package bdkeeper
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wurt83ow/gophermart/internal/models"
+)
+
+// GetUserTransactions returns the ledger entries of the user account from
+// the newest one with the balance after each entry, filtered and paginated.
+func (kp *BDKeeper) GetUserTransactions(userID string,
+	filter models.TransactionFilter,
+) ([]models.DataTransaction, error) {
+	ctx := context.Background()
+
+	conds := make([]string, 0)
+	args := []interface{}{userID}
+
+	if !filter.From.IsZero() {
+		args = append(args, filter.From)
+		conds = append(conds, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+
+	if !filter.To.IsZero() {
+		args = append(args, filter.To)
+		conds = append(conds, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+
+	if len(filter.Types) != 0 {
+		kinds := make([]string, 0, len(filter.Types))
+		for _, t := range filter.Types {
+			args = append(args, t)
+			kinds = append(kinds, fmt.Sprintf("$%d", len(args)))
+		}
+
+		conds = append(conds, fmt.Sprintf("kind IN (%s)", strings.Join(kinds, ", ")))
+	}
+
+	if filter.CursorID != "" {
+		args = append(args, filter.CursorDate, filter.CursorID)
+		conds = append(conds, fmt.Sprintf("(created_at, entry_id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	where := ""
+	if len(conds) != 0 {
+		where = "WHERE\n\t\t" + strings.Join(conds, "\n\t\tAND ")
+	}
+
+	args = append(args, filter.Limit)
+
+	// the running balance is counted over all the entries of the user
+	// before the filters are applied
+	sql := `
+	WITH _entries AS (
+		SELECT
+			e.entry_id,
+			e.kind,
+			COALESCE(e.reference, '') AS reference,
+			e.created_at,
+			SUM(p.amount) AS amount
+		FROM
+			postings AS p
+			INNER JOIN journal_entries AS e ON e.entry_id = p.entry_id
+		WHERE
+			p.account = $1
+		GROUP BY
+			e.entry_id,
+			e.kind,
+			e.reference,
+			e.created_at
+	),
+	_feed AS (
+		SELECT
+			*,
+			SUM(amount) OVER (ORDER BY created_at, entry_id) AS balance
+		FROM
+			_entries
+	)
+	SELECT
+		entry_id,
+		kind,
+		reference,
+		amount,
+		balance,
+		created_at
+	FROM
+		_feed
+	%s
+	ORDER BY
+		created_at DESC,
+		entry_id DESC
+	LIMIT $%d`
+	sql = fmt.Sprintf(sql, where, len(args))
+
+	rows, err := kp.conn.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user transactions by userID: %w", err)
+	}
+
+	defer rows.Close()
+
+	result := make([]models.DataTransaction, 0)
+
+	for rows.Next() {
+		var m models.DataTransaction
+
+		err := rows.Scan(&m.ID, &m.Type, &m.Order, &m.Amount, &m.Balance, &m.Date)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user transactions by userID: %w", err)
+		}
+
+		m.DateRFC = m.Date.Format(time.RFC3339)
+		result = append(result, m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get user transactions by userID: %w", err)
+	}
+
+	return result, nil
+}
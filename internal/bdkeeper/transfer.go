@@ -0,0 +1,248 @@
+package bdkeeper
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/wurt83ow/gophermart/internal/models"
+	"github.com/wurt83ow/gophermart/internal/storage"
+	"go.uber.org/zap"
+)
+
+// Transfer moves the points of the sender to the recipient in one entry.
+// The recipient gets the points of the same lots, so they expire as before.
+func (kp *BDKeeper) Transfer(transfer models.RequestTransfer) error {
+	ctx := context.Background()
+
+	err := kp.retryOnBalanceChange(zap.String("user", transfer.UserID), func() error {
+		return kp.transfer(ctx, transfer)
+	})
+	if errors.Is(err, errBalanceChanged) {
+		return fmt.Errorf("failed to transfer: %w", err)
+	}
+
+	return err
+}
+
+func (kp *BDKeeper) transfer(ctx context.Context, transfer models.RequestTransfer) error {
+	from, to, sum := transfer.UserID, transfer.RecipientID, transfer.Sum
+
+	// start the transaction
+	tx, err := kp.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to transfer: %w", err)
+	}
+
+	// if the commit is unsuccessful, all changes to the transaction will be rolled back
+	defer func() {
+		if err = tx.Rollback(); err != nil {
+			return
+		}
+	}()
+
+	// a retry with the same idempotency key gets the result of the first transfer
+	replay, err := kp.registerTransfer(ctx, tx, transfer)
+	if err != nil || replay {
+		return err
+	}
+
+	balance, fromVersion, err := kp.getBalance(ctx, tx, from)
+	if err != nil {
+		return fmt.Errorf("failed to transfer: %w", err)
+	}
+
+	if balance.Current-balance.Held < sum {
+		return storage.ErrInsufficient
+	}
+
+	_, toVersion, err := kp.getBalance(ctx, tx, to)
+	if err != nil {
+		return fmt.Errorf("failed to transfer: %w", err)
+	}
+
+	// the oldest lots of the sender move to the recipient
+	lots, left, err := kp.takeLots(ctx, tx, from, sum, "")
+	if err != nil {
+		return fmt.Errorf("failed to transfer: %w", err)
+	}
+
+	if left > 0 {
+		return errBalanceChanged
+	}
+
+	postings := make([]posting, 0, len(lots)*2)
+	for _, p := range lots {
+		postings = append(postings, p, posting{account: to, lot: p.lot, amount: -p.amount})
+	}
+
+	err = kp.postEntry(ctx, tx, entryTransfer, "", postings)
+	if err != nil {
+		return fmt.Errorf("failed to transfer: %w", err)
+	}
+
+	// update the balances in the order of the users to avoid deadlocks
+	changes := []struct {
+		userID  string
+		change  models.Money
+		version int64
+	}{
+		{from, -sum, fromVersion},
+		{to, sum, toVersion},
+	}
+	if to < from {
+		changes[0], changes[1] = changes[1], changes[0]
+	}
+
+	for _, c := range changes {
+		balance, err := kp.updateBalance(ctx, tx, c.userID, models.DataBalance{Current: c.change}, c.version)
+		if err != nil {
+			return err
+		}
+
+		if c.userID == from && balance.Current < balance.Held {
+			return storage.ErrInsufficient
+		}
+	}
+
+	// the received points pay off the debts of the recipient first
+	err = kp.settleDebts(ctx, tx, []string{to})
+	if err != nil {
+		return fmt.Errorf("failed to transfer: %w", err)
+	}
+
+	// commit the transaction
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to transfer: %w", err)
+	}
+
+	return nil
+}
+
+// registerTransfer saves the idempotency key of the transfer in the transaction.
+// It returns true if the same transfer has already been made with the key,
+// and storage.ErrConflict if the key was used by another transfer of the user.
+func (kp *BDKeeper) registerTransfer(ctx context.Context, tx *sql.Tx, transfer models.RequestTransfer) (bool, error) {
+	if transfer.IdempotencyKey == "" {
+		return false, nil
+	}
+
+	query := `
+	INSERT INTO transfers (user_id, idempotency_key, recipient_id, sum, processed_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+	ON CONFLICT
+		DO NOTHING`
+
+	res, err := tx.ExecContext(ctx, query, transfer.UserID, transfer.IdempotencyKey,
+		transfer.RecipientID, transfer.Sum)
+	if err != nil {
+		return false, fmt.Errorf("failed to register transfer: %w", err)
+	}
+
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to register transfer: %w", err)
+	}
+
+	if inserted != 0 {
+		return false, nil
+	}
+
+	query = `
+	SELECT
+		recipient_id,
+		sum
+	FROM
+		transfers
+	WHERE
+		user_id = $1
+		AND idempotency_key = $2`
+	row := tx.QueryRowContext(ctx, query, transfer.UserID, transfer.IdempotencyKey)
+
+	var m models.RequestTransfer
+
+	err = row.Scan(&m.RecipientID, &m.Sum)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("failed to register transfer: %w", storage.ErrConflict)
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("failed to register transfer: %w", err)
+	}
+
+	if m.RecipientID != transfer.RecipientID || m.Sum != transfer.Sum {
+		return false, storage.ErrConflict
+	}
+
+	return true, nil
+}
+
+// GetUserTransfers returns the points the user has sent and received.
+func (kp *BDKeeper) GetUserTransfers(userID string) ([]models.DataTransfer, error) {
+	ctx := context.Background()
+
+	// the counterparty of a transfer is the other user account of the entry
+	sql := `
+	SELECT
+		u.email AS login,
+		SUM(p.amount) AS sum,
+		e.created_at AS date
+	FROM
+		journal_entries AS e
+		INNER JOIN postings AS p ON p.entry_id = e.entry_id
+			AND p.account = $1
+		INNER JOIN LATERAL (
+			SELECT
+				account
+			FROM
+				postings
+			WHERE
+				entry_id = e.entry_id
+				AND account <> $1
+			LIMIT 1) AS c ON TRUE
+		INNER JOIN users AS u ON u.user_id = c.account
+	WHERE
+		e.kind = 'transfer'
+	GROUP BY
+		e.entry_id,
+		e.created_at,
+		u.email
+	ORDER BY
+		e.created_at`
+
+	rows, err := kp.conn.QueryContext(ctx, sql, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user transfers by userID: %w", err)
+	}
+
+	defer rows.Close()
+
+	result := make([]models.DataTransfer, 0)
+
+	for rows.Next() {
+		var m models.DataTransfer
+
+		err := rows.Scan(&m.Login, &m.Sum, &m.Date)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user transfers by userID: %w", err)
+		}
+
+		m.Direction = models.TransferIn
+		if m.Sum < 0 {
+			m.Direction = models.TransferOut
+			m.Sum = -m.Sum
+		}
+
+		m.DateRFC = m.Date.Format(time.RFC3339)
+		result = append(result, m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get user transfers by userID: %w", err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,160 @@
+package breaker
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// ErrOpen is returned when the circuit breaker rejects a request.
+var ErrOpen = errors.New("circuit breaker is open")
+
+type Log interface {
+	Info(string, ...zapcore.Field)
+}
+
+// State of the circuit breaker.
+type State int
+
+const (
+	// StateClosed lets all requests through.
+	StateClosed State = iota
+	// StateOpen rejects all requests until the cooldown is over.
+	StateOpen
+	// StateHalfOpen lets a single trial request through.
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreaker stops requests to a failing service.
+type CircuitBreaker struct {
+	mx        sync.Mutex
+	state     State
+	failures  int
+	probing   bool
+	openedAt  time.Time
+	threshold int
+	cooldown  time.Duration
+	log       Log
+}
+
+// NewCircuitBreaker returns a closed circuit breaker which opens after threshold
+// consecutive failures and tries the service again after the cooldown in milliseconds.
+func NewCircuitBreaker(threshold func() string, cooldown func() string, log Log) *CircuitBreaker {
+	thr, err := strconv.Atoi(threshold())
+	if err != nil || thr < 1 {
+		log.Info("cannot convert breaker failure threshold option: ", zap.Error(err))
+		thr = 5
+	}
+
+	cd, err := strconv.Atoi(cooldown())
+	if err != nil {
+		log.Info("cannot convert breaker cooldown option: ", zap.Error(err))
+		cd = 30000
+	}
+
+	return &CircuitBreaker{
+		state:     StateClosed,
+		threshold: thr,
+		cooldown:  time.Duration(cd) * time.Millisecond,
+		log:       log,
+	}
+}
+
+// Allow reports whether a request may be sent.
+// Every allowed request must be followed by Success, Failure or Release.
+func (cb *CircuitBreaker) Allow() error {
+	cb.mx.Lock()
+	defer cb.mx.Unlock()
+
+	switch cb.state {
+	case StateOpen:
+		if time.Since(cb.openedAt) < cb.cooldown {
+			return ErrOpen
+		}
+
+		cb.setState(StateHalfOpen)
+		cb.probing = true
+
+		return nil
+	case StateHalfOpen:
+		// only one trial request at a time
+		if cb.probing {
+			return ErrOpen
+		}
+
+		cb.probing = true
+
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Success records a successful request and closes the breaker.
+func (cb *CircuitBreaker) Success() {
+	cb.mx.Lock()
+	defer cb.mx.Unlock()
+
+	cb.failures = 0
+	cb.probing = false
+	cb.setState(StateClosed)
+}
+
+// Failure records a failed request and opens the breaker
+// when the threshold is reached or the trial request failed.
+func (cb *CircuitBreaker) Failure() {
+	cb.mx.Lock()
+	defer cb.mx.Unlock()
+
+	cb.probing = false
+	cb.failures++
+
+	if cb.state == StateHalfOpen || cb.failures >= cb.threshold {
+		cb.openedAt = time.Now()
+		cb.setState(StateOpen)
+	}
+}
+
+// Release ends an allowed request which tells nothing about
+// the health of the service, for example a canceled one.
+func (cb *CircuitBreaker) Release() {
+	cb.mx.Lock()
+	defer cb.mx.Unlock()
+
+	cb.probing = false
+}
+
+// State returns the current state of the breaker.
+func (cb *CircuitBreaker) State() State {
+	cb.mx.Lock()
+	defer cb.mx.Unlock()
+
+	return cb.state
+}
+
+func (cb *CircuitBreaker) setState(state State) {
+	if cb.state == state {
+		return
+	}
+
+	cb.log.Info("circuit breaker state changed: ",
+		zap.String("from", cb.state.String()), zap.String("to", state.String()))
+	cb.state = state
+}
@@ -8,7 +8,14 @@ import (
 type Options struct {
 	flagRunAddr, flagLogLevel, flagDataBaseDSN,
 	flagJWTSigningKey, flagAccrualSystemAddress,
-	flagConcurrency, flagTaskExecutionInterval string
+	flagConcurrency, flagTaskExecutionInterval,
+	flagUnregisteredTimeout, flagMaxPollBackoff,
+	flagOrderLeaseTimeout, flagBreakerThreshold,
+	flagBreakerCooldown, flagWebhookSecret,
+	flagPushWindow, flagTaskTimeout,
+	flagAdminToken, flagRateLimit,
+	flagReversalPolicy, flagPointsLifetime,
+	flagExpiringWindow, flagHoldTimeout string
 }
 
 func NewOptions() *Options {
@@ -19,12 +26,29 @@ func NewOptions() *Options {
 // and stores their values in the corresponding variables.
 func (o *Options) ParseFlags() {
 	regStringVar(&o.flagRunAddr, "a", ":8080", "address and port to run server")
+	regStringVar(&o.flagMaxPollBackoff, "b", "3600000", "Maximum backoff of order polling in milliseconds")
 	regStringVar(&o.flagConcurrency, "c", "5", "Concurrency")
 	regStringVar(&o.flagDataBaseDSN, "d", "", "")
+	regStringVar(&o.flagPointsLifetime, "e", "0", "Lifetime of the accrued points in hours, 0 - unlimited")
+	regStringVar(&o.flagBreakerThreshold, "f", "5", "Failures in a row that open the accrual circuit breaker")
+	regStringVar(&o.flagExpiringWindow, "g", "168", "Window in hours to report the points as expiring soon")
 	regStringVar(&o.flagTaskExecutionInterval, "i", "3000", "Task execution interval in milliseconds")
 	regStringVar(&o.flagJWTSigningKey, "j", "test_key", "jwt signing key")
+	regStringVar(&o.flagAdminToken, "k", "", "admin api token")
 	regStringVar(&o.flagLogLevel, "l", "info", "log level")
+	regStringVar(&o.flagHoldTimeout, "m", "15", "Timeout of a points hold in minutes")
+	regStringVar(&o.flagBreakerCooldown, "o", "30000", "Accrual circuit breaker cooldown in milliseconds")
+	regStringVar(&o.flagReversalPolicy, "p", "clawback",
+		"Policy of the accrual reversal when the points are spent: negative or clawback")
+	regStringVar(&o.flagRateLimit, "q", "0",
+		"Accrual system requests per minute, 0 - unlimited until the first throttling")
 	regStringVar(&o.flagAccrualSystemAddress, "r", ":8082", "acrual system address")
+	regStringVar(&o.flagWebhookSecret, "s", "", "Secret of the accrual webhook signature")
+	regStringVar(&o.flagOrderLeaseTimeout, "t", "60000", "Lease timeout of a polled order in milliseconds")
+	regStringVar(&o.flagUnregisteredTimeout, "u", "24",
+		"hours after which an order unknown to the accrual system is marked invalid")
+	regStringVar(&o.flagPushWindow, "w", "0", "Window in milliseconds to wait for a push before polling an order")
+	regStringVar(&o.flagTaskTimeout, "x", "10000", "Timeout of an accrual task in milliseconds")
 
 	// parse the arguments passed to the server into registered variables
 	flag.Parse()
@@ -56,6 +80,62 @@ func (o *Options) ParseFlags() {
 	if envTaskExecutionInterval := os.Getenv("TASK_EXECUTION_INTERVAL"); envTaskExecutionInterval != "" {
 		o.flagTaskExecutionInterval = envTaskExecutionInterval
 	}
+
+	if envUnregisteredTimeout := os.Getenv("UNREGISTERED_ORDER_TIMEOUT"); envUnregisteredTimeout != "" {
+		o.flagUnregisteredTimeout = envUnregisteredTimeout
+	}
+
+	if envMaxPollBackoff := os.Getenv("MAX_POLL_BACKOFF"); envMaxPollBackoff != "" {
+		o.flagMaxPollBackoff = envMaxPollBackoff
+	}
+
+	if envOrderLeaseTimeout := os.Getenv("ORDER_LEASE_TIMEOUT"); envOrderLeaseTimeout != "" {
+		o.flagOrderLeaseTimeout = envOrderLeaseTimeout
+	}
+
+	if envBreakerThreshold := os.Getenv("BREAKER_FAILURE_THRESHOLD"); envBreakerThreshold != "" {
+		o.flagBreakerThreshold = envBreakerThreshold
+	}
+
+	if envBreakerCooldown := os.Getenv("BREAKER_COOLDOWN"); envBreakerCooldown != "" {
+		o.flagBreakerCooldown = envBreakerCooldown
+	}
+
+	if envWebhookSecret := os.Getenv("WEBHOOK_SECRET"); envWebhookSecret != "" {
+		o.flagWebhookSecret = envWebhookSecret
+	}
+
+	if envPushWindow := os.Getenv("PUSH_FALLBACK_WINDOW"); envPushWindow != "" {
+		o.flagPushWindow = envPushWindow
+	}
+
+	if envTaskTimeout := os.Getenv("TASK_TIMEOUT"); envTaskTimeout != "" {
+		o.flagTaskTimeout = envTaskTimeout
+	}
+
+	if envAdminToken := os.Getenv("ADMIN_TOKEN"); envAdminToken != "" {
+		o.flagAdminToken = envAdminToken
+	}
+
+	if envRateLimit := os.Getenv("ACCRUAL_RATE_LIMIT"); envRateLimit != "" {
+		o.flagRateLimit = envRateLimit
+	}
+
+	if envReversalPolicy := os.Getenv("REVERSAL_POLICY"); envReversalPolicy != "" {
+		o.flagReversalPolicy = envReversalPolicy
+	}
+
+	if envPointsLifetime := os.Getenv("POINTS_LIFETIME"); envPointsLifetime != "" {
+		o.flagPointsLifetime = envPointsLifetime
+	}
+
+	if envExpiringWindow := os.Getenv("POINTS_EXPIRING_WINDOW"); envExpiringWindow != "" {
+		o.flagExpiringWindow = envExpiringWindow
+	}
+
+	if envHoldTimeout := os.Getenv("HOLD_TIMEOUT"); envHoldTimeout != "" {
+		o.flagHoldTimeout = envHoldTimeout
+	}
 }
 
 func (o *Options) RunAddr() string {
@@ -86,6 +166,62 @@ func (o *Options) TaskExecutionInterval() string {
 	return getStringFlag("i")
 }
 
+func (o *Options) UnregisteredTimeout() string {
+	return getStringFlag("u")
+}
+
+func (o *Options) MaxPollBackoff() string {
+	return getStringFlag("b")
+}
+
+func (o *Options) OrderLeaseTimeout() string {
+	return getStringFlag("t")
+}
+
+func (o *Options) BreakerThreshold() string {
+	return getStringFlag("f")
+}
+
+func (o *Options) BreakerCooldown() string {
+	return getStringFlag("o")
+}
+
+func (o *Options) WebhookSecret() string {
+	return getStringFlag("s")
+}
+
+func (o *Options) PushWindow() string {
+	return getStringFlag("w")
+}
+
+func (o *Options) TaskTimeout() string {
+	return getStringFlag("x")
+}
+
+func (o *Options) AdminToken() string {
+	return getStringFlag("k")
+}
+
+func (o *Options) RateLimit() string {
+	return getStringFlag("q")
+}
+
+func (o *Options) ReversalPolicy() string {
+	return getStringFlag("p")
+}
+
+func (o *Options) PointsLifetime() string {
+	return getStringFlag("e")
+}
+
+func (o *Options) ExpiringWindow() string {
+	return getStringFlag("g")
+}
+
+func (o *Options) HoldTimeout() string {
+	return getStringFlag("m")
+}
+
 func regStringVar(p *string, name string, value string, usage string) {
 	if flag.Lookup(name) == nil {
 		flag.StringVar(p, name, value, usage)
@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/wurt83ow/gophermart/internal/models"
+	"github.com/wurt83ow/gophermart/internal/storage"
+	"github.com/wurt83ow/gophermart/internal/workerpool"
+	"go.uber.org/zap"
+)
+
+type Reverser interface {
+	ReverseAccrual(string, string) (models.DataReversal, error)
+}
+
+type TaskPool interface {
+	DeadLetters() []workerpool.DeadLetter
+	Requeue(int) error
+	Stats() workerpool.Stats
+	Resize(int) error
+}
+
+// AdminController serves the service operations,
+// requests must pass the admin token in the X-Admin-Token header.
+type AdminController struct {
+	pool           TaskPool
+	reverser       Reverser
+	token          []byte
+	reversalPolicy string
+	log            Log
+}
+
+func NewAdminController(pool TaskPool, reverser Reverser, token string,
+	reversalPolicy string, log Log,
+) *AdminController {
+	return &AdminController{
+		pool:           pool,
+		reverser:       reverser,
+		token:          []byte(token),
+		reversalPolicy: reversalPolicy,
+		log:            log,
+	}
+}
+
+func (h *AdminController) Route() *chi.Mux {
+	r := chi.NewRouter()
+	r.Use(h.AdminMiddleware)
+
+	r.Get("/metrics", h.GetMetrics)
+	r.Post("/pool/size", h.ResizePool)
+	r.Get("/tasks/dead", h.GetDeadLetters)
+	r.Post("/tasks/dead/{id}/requeue", h.Requeue)
+	r.Post("/orders/{number}/reversal", h.ReverseAccrual)
+
+	return r
+}
+
+// AdminMiddleware checks the admin token, the admin api
+// is disabled when the token is not configured.
+func (h *AdminController) AdminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := []byte(r.Header.Get("X-Admin-Token"))
+		if len(h.token) == 0 || subtle.ConstantTimeCompare(token, h.token) != 1 {
+			w.WriteHeader(http.StatusForbidden) //code 403
+			h.log.Info("invalid admin token, request status 403: ", zap.String("path", r.URL.Path))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *AdminController) GetMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// serialize the server response
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(h.pool.Stats()); err != nil {
+		// Internal Server Error
+		w.WriteHeader(http.StatusInternalServerError) //code 500
+		h.log.Info("Internal Server Error: ", zap.Error(err))
+		return
+	}
+}
+
+func (h *AdminController) ResizePool(w http.ResponseWriter, r *http.Request) {
+	metod := zap.String("method", r.Method)
+
+	req := models.RequestPoolSize{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest) //code 400
+		h.log.Info("cannot decode request JSON body: ", zap.Error(err))
+		return
+	}
+
+	err := h.pool.Resize(req.Workers)
+	if err != nil {
+		if errors.Is(err, workerpool.ErrInvalidPoolSize) {
+			w.WriteHeader(http.StatusUnprocessableEntity) //code 422
+			h.log.Info("invalid pool size, request status 422: ", metod, zap.Int("workers", req.Workers))
+		} else {
+			w.WriteHeader(http.StatusInternalServerError) //code 500
+			h.log.Info("internal server error, request status 500: ", zap.Error(err))
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK) //code 200
+}
+
+func (h *AdminController) GetDeadLetters(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	metod := zap.String("method", r.Method)
+
+	letters := h.pool.DeadLetters()
+
+	if len(letters) == 0 {
+		// no information to answer
+		w.WriteHeader(http.StatusNoContent) // 204
+		h.log.Info("no information to answer, request status 204: ", metod)
+		return
+	}
+
+	// serialize the server response
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(letters); err != nil {
+		// Internal Server Error
+		w.WriteHeader(http.StatusInternalServerError) //code 500
+		h.log.Info("Internal Server Error: ", zap.Error(err))
+		return
+	}
+}
+
+func (h *AdminController) Requeue(w http.ResponseWriter, r *http.Request) {
+	metod := zap.String("method", r.Method)
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest) //code 400
+		h.log.Info("invalid dead letter id, request status 400: ", metod)
+		return
+	}
+
+	err = h.pool.Requeue(id)
+	if err != nil {
+		if errors.Is(err, workerpool.ErrDeadLetterNotFound) {
+			w.WriteHeader(http.StatusNotFound) //code 404
+			h.log.Info("dead letter not found, request status 404: ", zap.Int("id", id))
+		} else if errors.Is(err, workerpool.ErrQueueFull) || errors.Is(err, workerpool.ErrPoolStopped) {
+			// the task is kept in the dead letters
+			w.WriteHeader(http.StatusServiceUnavailable) //code 503
+			h.log.Info("task queue is not available, request status 503: ", zap.Error(err))
+		} else {
+			w.WriteHeader(http.StatusInternalServerError) //code 500
+			h.log.Info("internal server error, request status 500: ", zap.Error(err))
+		}
+		return
+	}
+
+	// task accepted for processing
+	w.WriteHeader(http.StatusAccepted) //code 202
+}
+
+// ReverseAccrual reverses the accrual of a cancelled order, the policy
+// of the request body overrides the configured one.
+func (h *AdminController) ReverseAccrual(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	metod := zap.String("method", r.Method)
+
+	number := chi.URLParam(r, "number")
+
+	req := models.RequestReversal{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		w.WriteHeader(http.StatusBadRequest) //code 400
+		h.log.Info("cannot decode request JSON body: ", zap.Error(err))
+		return
+	}
+
+	if req.Policy == "" {
+		req.Policy = h.reversalPolicy
+	}
+
+	if req.Policy != models.ReversalNegative && req.Policy != models.ReversalClawback {
+		w.WriteHeader(http.StatusUnprocessableEntity) //code 422
+		h.log.Info("invalid reversal policy, request status 422: ", metod, zap.String("policy", req.Policy))
+		return
+	}
+
+	reversal, err := h.reverser.ReverseAccrual(number, req.Policy)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			w.WriteHeader(http.StatusNotFound) //code 404
+			h.log.Info("accrual not found, request status 404: ", zap.String("order", number))
+		case errors.Is(err, storage.ErrConflict):
+			w.WriteHeader(http.StatusConflict) //code 409
+			h.log.Info("accrual already reversed, request status 409: ", zap.String("order", number))
+		default:
+			w.WriteHeader(http.StatusInternalServerError) //code 500
+			h.log.Info("internal server error, request status 500: ", zap.Error(err))
+		}
+		return
+	}
+
+	// serialize the server response
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(reversal); err != nil {
+		// Internal Server Error
+		w.WriteHeader(http.StatusInternalServerError) //code 500
+		h.log.Info("Internal Server Error: ", zap.Error(err))
+		return
+	}
+}
@@ -3,6 +3,7 @@ package controllers
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"strconv"
@@ -11,6 +12,7 @@ import (
 	"github.com/go-chi/chi"
 	"github.com/google/uuid"
 	authz "github.com/wurt83ow/gophermart/internal/authorization"
+	"github.com/wurt83ow/gophermart/internal/breaker"
 	"github.com/wurt83ow/gophermart/internal/models"
 	"github.com/wurt83ow/gophermart/internal/storage"
 	"go.uber.org/zap"
@@ -27,16 +29,26 @@ type Storage interface {
 	InsertOrder(string, models.DataOrder) (models.DataOrder, error)
 	InsertUser(string, models.DataUser) (models.DataUser, error)
 	GetUser(string) (models.DataUser, error)
-	GetUserOrders(string) []models.DataOrder
+	FindUser(string) (models.DataUser, error)
+	GetUserOrders(string, models.OrderFilter) ([]models.DataOrder, error)
+	GetUserOrder(string, string) (models.DataOrderDetail, error)
 	GetUserWithdrawals(string) ([]models.DataWithdraw, error)
 	GetUserBalance(string) (models.DataBalance, error)
 	GetBaseConnection() bool
+	HasKeeper() bool
 	Withdraw(models.DataWithdraw) error
+	CreateHold(models.DataHold, time.Duration) (models.DataHold, error)
+	CaptureHold(string, string) (models.DataHold, error)
+	ReleaseHold(string, string) (models.DataHold, error)
+	Transfer(models.RequestTransfer) error
+	GetUserTransfers(string) ([]models.DataTransfer, error)
+	GetUserTransactions(string, models.TransactionFilter) ([]models.DataTransaction, error)
 }
 
 type Options interface {
 	ParseFlags()
 	RunAddr() string
+	HoldTimeout() string
 }
 
 type Log interface {
@@ -50,19 +62,42 @@ type Authz interface {
 	AuthCookie(string, string) *http.Cookie
 }
 
+type BreakerState interface {
+	State() breaker.State
+}
+
+type Expiry interface {
+	ExpiringSoon(string) (models.Money, error)
+}
+
 type BaseController struct {
-	storage Storage
-	options Options
-	log     Log
-	authz   Authz
+	storage     Storage
+	options     Options
+	log         Log
+	authz       Authz
+	breaker     BreakerState
+	expiry      Expiry
+	holdTimeout time.Duration
 }
 
-func NewBaseController(storage Storage, options Options, log Log, authz Authz) *BaseController {
+func NewBaseController(storage Storage, options Options, log Log, authz Authz,
+	breaker BreakerState, expiry Expiry,
+) *BaseController {
+	holdTimeout, err := strconv.Atoi(options.HoldTimeout())
+	if err != nil {
+		log.Info("cannot convert hold timeout option: ", zap.Error(err))
+
+		holdTimeout = 15
+	}
+
 	instance := &BaseController{
-		storage: storage,
-		options: options,
-		log:     log,
-		authz:   authz,
+		storage:     storage,
+		options:     options,
+		log:         log,
+		authz:       authz,
+		breaker:     breaker,
+		expiry:      expiry,
+		holdTimeout: time.Duration(holdTimeout) * time.Minute,
 	}
 
 	return instance
@@ -74,6 +109,7 @@ func (h *BaseController) Route() *chi.Mux {
 	r.Post("/api/user/register", h.Register)
 	r.Post("/api/user/login", h.Login)
 	r.Get("/ping", h.GetPing)
+	r.Get("/health", h.GetHealth)
 
 	// group where the middleware authorization is needed
 	r.Group(func(r chi.Router) {
@@ -81,9 +117,16 @@ func (h *BaseController) Route() *chi.Mux {
 
 		r.Post("/api/user/orders", h.CreateOrder)
 		r.Get("/api/user/orders", h.GetUserOrders)
+		r.Get("/api/user/orders/{number}", h.GetUserOrder)
 		r.Get("/api/user/balance", h.GetUserBalance)
 		r.Post("/api/user/balance/withdraw", h.Withdraw)
+		r.Post("/api/user/balance/holds", h.CreateHold)
+		r.Post("/api/user/balance/holds/{id}/capture", h.CaptureHold)
+		r.Post("/api/user/balance/holds/{id}/release", h.ReleaseHold)
 		r.Get("/api/user/withdrawals", h.GetUserWithdrawals)
+		r.Post("/api/user/balance/transfer", h.Transfer)
+		r.Get("/api/user/transfers", h.GetUserTransfers)
+		r.Get("/api/user/transactions", h.GetUserTransactions)
 	})
 
 	return r
@@ -205,6 +248,37 @@ func (h *BaseController) GetPing(w http.ResponseWriter, r *http.Request) {
 	h.log.Info("sending HTTP 200 response")
 }
 
+func (h *BaseController) GetHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// read the breaker state once, so the body and the status code agree
+	state := h.breaker.State()
+
+	health := models.DataHealth{Database: "up", Accrual: state.String()}
+	healthy := state != breaker.StateOpen
+
+	switch {
+	case !h.storage.HasKeeper():
+		// the service works in memory without a database
+		health.Database = "disabled"
+	case !h.storage.GetBaseConnection():
+		health.Database = "down"
+		healthy = false
+	}
+
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable) // 503
+		h.log.Info("service is unhealthy, request status 503: ",
+			zap.String("database", health.Database), zap.String("accrual", health.Accrual))
+	}
+
+	// serialize the server response
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(health); err != nil {
+		h.log.Info("Internal Server Error: ", zap.Error(err))
+	}
+}
+
 func (h *BaseController) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	metod := zap.String("method", r.Method)
 
@@ -282,7 +356,31 @@ func (h *BaseController) GetUserOrders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	orders := h.storage.GetUserOrders(userID)
+	filter, err := parseOrderFilter(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest) //code 400
+		h.log.Info("invalid orders query, request status 400: ", metod, zap.Error(err))
+		return
+	}
+
+	// one more order is requested to know whether there is a next page
+	limit := filter.Limit
+	if limit != 0 {
+		filter.Limit++
+	}
+
+	orders, err := h.storage.GetUserOrders(userID, filter)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError) //code 500
+		h.log.Info("Internal Server Error: ", zap.Error(err))
+		return
+	}
+
+	if limit != 0 && len(orders) > limit {
+		orders = orders[:limit]
+		last := orders[limit-1]
+		w.Header().Set("X-Next-Cursor", encodeCursor(last.Date, last.Number))
+	}
 
 	if len(orders) == 0 {
 		// no information to answer
@@ -323,6 +421,13 @@ func (h *BaseController) GetUserBalance(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	balance.ExpiringSoon, err = h.expiry.ExpiringSoon(userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError) //code 500
+		h.log.Info("Internal Server Error: ", zap.Error(err))
+		return
+	}
+
 	// serialize the server response
 	enc := json.NewEncoder(w)
 	if err := enc.Encode(balance); err != nil {
@@ -362,12 +467,25 @@ func (h *BaseController) Withdraw(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if regReq.Sum <= 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity) //code 422
+		h.log.Info("incorrect withdrawal sum, request status 422: ", metod)
+		return
+	}
+
 	regReq.UserID = userID
+	regReq.IdempotencyKey = r.Header.Get("Idempotency-Key")
+
+	// a retry of the same withdrawal returns the original result
 	err = h.storage.Withdraw(regReq)
 	if err != nil {
 		if err == storage.ErrInsufficient {
 			w.WriteHeader(http.StatusPaymentRequired) //code 402
 			h.log.Info("there are insufficient funds in the account, request status 402: ", metod)
+		} else if errors.Is(err, storage.ErrConflict) {
+			// the order or the key is used by another withdrawal
+			w.WriteHeader(http.StatusConflict) //code 409
+			h.log.Info("withdrawal conflicts with a previous one, request status 409: ", metod)
 		} else {
 			h.log.Info("cannot decode request JSON body: ", zap.Error(err))
 			w.WriteHeader(http.StatusInternalServerError) // code 500
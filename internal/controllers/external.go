@@ -1,17 +1,58 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/wurt83ow/gophermart/internal/models"
 	"go.uber.org/zap"
 )
 
+// defaultRetryAfter is used when the accrual system answers 429
+// without a valid Retry-After header.
+const defaultRetryAfter = 60 * time.Second
+
+// ErrOrderNotRegistered indicates that the order is unknown to the accrual system.
+var ErrOrderNotRegistered = errors.New("order is not registered in the accrual system")
+
+// ErrUnknownAccrualStatus indicates that the accrual system returned an unexpected order status.
+var ErrUnknownAccrualStatus = errors.New("unknown accrual status")
+
+// TooManyRequestsError indicates that the accrual system throttles our requests.
+type TooManyRequestsError struct {
+	RetryAfter time.Duration
+}
+
+func (e *TooManyRequestsError) Error() string {
+	return fmt.Sprintf("accrual system: too many requests, retry after %s", e.RetryAfter)
+}
+
+type Breaker interface {
+	Allow() error
+	Success()
+	Failure()
+	Release()
+}
+
+type Limiter interface {
+	Wait(context.Context) error
+	Decrease()
+	Increase()
+}
+
 type ExtController struct {
-	storage Storage
-	log     Log
-	extAddr func() string
+	storage  Storage
+	log      Log
+	extAddr  func() string
+	throttle *throttle
+	breaker  Breaker
+	limiter  Limiter
 }
 
 type Pool interface {
@@ -19,15 +60,20 @@ type Pool interface {
 	GetResults() <-chan interface{}
 }
 
-func NewExtController(storage Storage, extAddr func() string, log Log) *ExtController {
+func NewExtController(storage Storage, extAddr func() string, breaker Breaker,
+	limiter Limiter, log Log,
+) *ExtController {
 	return &ExtController{
-		storage: storage,
-		log:     log,
-		extAddr: extAddr,
+		storage:  storage,
+		log:      log,
+		extAddr:  extAddr,
+		throttle: new(throttle),
+		breaker:  breaker,
+		limiter:  limiter,
 	}
 }
 
-func (c *ExtController) GetExtOrderAccruel(order string) (models.ExtRespOrder, error) {
+func (c *ExtController) GetExtOrderAccruel(ctx context.Context, order string) (models.ExtRespOrder, error) {
 	addr := c.extAddr()
 	if string(addr[len(addr)-1]) != "/" {
 		addr = addr + "/"
@@ -35,15 +81,74 @@ func (c *ExtController) GetExtOrderAccruel(order string) (models.ExtRespOrder, e
 
 	url := addr + "api/orders/" + order
 
-	resp, err := http.Get(url)
+	// all workers share the same throttle, so while the accrual system
+	// asks us to slow down nobody sends requests to it
+	if err := c.throttle.wait(ctx); err != nil {
+		return models.ExtRespOrder{}, fmt.Errorf("failed to wait for accrual system: %w", err)
+	}
+
+	// all workers share the same rate limit
+	if err := c.limiter.Wait(ctx); err != nil {
+		return models.ExtRespOrder{}, err
+	}
+
+	// don't send requests while the accrual system is known to be down
+	if err := c.breaker.Allow(); err != nil {
+		return models.ExtRespOrder{}, fmt.Errorf("accrual system is unavailable: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		c.breaker.Release()
+
+		return models.ExtRespOrder{}, fmt.Errorf("failed to create accrual system request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		c.log.Info("cannot convert concurrency option: ", zap.Error(err))
+		// a canceled request says nothing about the health of the accrual system
+		if ctx.Err() != nil {
+			c.breaker.Release()
+
+			return models.ExtRespOrder{}, fmt.Errorf("accrual system request canceled: %w", err)
+		}
+
+		c.breaker.Failure()
+		c.log.Info("accrual system request error: ", zap.Error(err))
+
+		return models.ExtRespOrder{}, fmt.Errorf("failed to request accrual system: %w", err)
 	}
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= http.StatusInternalServerError {
+		c.breaker.Failure()
+	} else {
+		c.breaker.Success()
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+		c.throttle.pause(retryAfter)
+		c.limiter.Decrease()
+		c.log.Info("accrual system is throttling requests: ",
+			zap.Duration("retry_after", retryAfter))
+
+		return models.ExtRespOrder{}, &TooManyRequestsError{RetryAfter: retryAfter}
+	}
+
+	if resp.StatusCode < http.StatusInternalServerError {
+		c.limiter.Increase()
+	}
+
+	if resp.StatusCode == http.StatusNoContent {
+		return models.ExtRespOrder{}, ErrOrderNotRegistered
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		c.log.Info("status code error: ", zap.String("method", resp.Status))
+
+		return models.ExtRespOrder{}, fmt.Errorf("unexpected accrual system response: %s", resp.Status)
 	}
 
 	respOrd := models.ExtRespOrder{}
@@ -54,3 +159,62 @@ func (c *ExtController) GetExtOrderAccruel(order string) (models.ExtRespOrder, e
 
 	return respOrd, nil
 }
+
+// parseRetryAfter reads the Retry-After header value,
+// which may be either a number of seconds or an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return defaultRetryAfter
+	}
+
+	if sec, err := strconv.Atoi(value); err == nil && sec >= 0 {
+		return time.Duration(sec) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+
+		return 0
+	}
+
+	return defaultRetryAfter
+}
+
+// throttle holds the global pause of outbound requests to the accrual system.
+type throttle struct {
+	mx    sync.RWMutex
+	until time.Time
+}
+
+// pause suspends outbound requests for the given interval.
+func (t *throttle) pause(d time.Duration) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if until := time.Now().Add(d); until.After(t.until) {
+		t.until = until
+	}
+}
+
+// wait blocks until the current pause is over or the context is done.
+func (t *throttle) wait(ctx context.Context) error {
+	for {
+		t.mx.RLock()
+		d := time.Until(t.until)
+		t.mx.RUnlock()
+
+		if d <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
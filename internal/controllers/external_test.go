@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/wurt83ow/gophermart/internal/accrualstub"
+	"github.com/wurt83ow/gophermart/internal/breaker"
+	"github.com/wurt83ow/gophermart/internal/models"
+	"github.com/wurt83ow/gophermart/internal/ratelimit"
+	"go.uber.org/zap"
+)
+
+// newStubController starts the accrual stub and returns the controller requesting it.
+func newStubController(t *testing.T, cfg accrualstub.Config) (*ExtController, *httptest.Server, *breaker.CircuitBreaker) {
+	t.Helper()
+
+	log := zap.NewNop()
+	srv := httptest.NewServer(accrualstub.NewServer(cfg, log).Route())
+	t.Cleanup(srv.Close)
+
+	cb := breaker.NewCircuitBreaker(func() string { return "3" }, func() string { return "60000" }, log)
+	limiter := ratelimit.NewLimiter(func() string { return "60000" }, log)
+
+	return NewExtController(nil, func() string { return srv.URL }, cb, limiter, log), srv, cb
+}
+
+// registerStubOrder registers the order with the goods in the stub.
+func registerStubOrder(t *testing.T, srv *httptest.Server, order accrualstub.RequestOrder) {
+	t.Helper()
+
+	body, err := json.Marshal(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(srv.URL+"/api/orders", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("register order: got status %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+}
+
+func TestGetExtOrderAccruelNotRegistered(t *testing.T) {
+	c, _, _ := newStubController(t, accrualstub.Config{})
+
+	_, err := c.GetExtOrderAccruel(context.Background(), "12345678903")
+	if !errors.Is(err, ErrOrderNotRegistered) {
+		t.Fatalf("got error %v, want %v", err, ErrOrderNotRegistered)
+	}
+}
+
+func TestGetExtOrderAccruelStatuses(t *testing.T) {
+	c, srv, _ := newStubController(t, accrualstub.Config{
+		Rules:           []accrualstub.Rule{{Match: "Bork", Reward: 1000, RewardType: "%"}},
+		Invalid:         []string{"2377225624"},
+		ProcessingPolls: 2,
+	})
+
+	goods := []accrualstub.Good{{Description: "Чайник Bork", Price: 700000}}
+	registerStubOrder(t, srv, accrualstub.RequestOrder{Order: "12345678903", Goods: goods})
+	registerStubOrder(t, srv, accrualstub.RequestOrder{Order: "2377225624", Goods: goods})
+
+	tests := []struct {
+		name  string
+		order string
+		want  models.ExtRespOrder
+	}{
+		{"registered", "12345678903", models.ExtRespOrder{Order: "12345678903", Status: "REGISTERED"}},
+		{"processing", "12345678903", models.ExtRespOrder{Order: "12345678903", Status: "PROCESSING"}},
+		{"processed", "12345678903", models.ExtRespOrder{Order: "12345678903", Status: "PROCESSED", Accrual: 70000}},
+		{"processed again", "12345678903", models.ExtRespOrder{Order: "12345678903", Status: "PROCESSED", Accrual: 70000}},
+		{"invalid registered", "2377225624", models.ExtRespOrder{Order: "2377225624", Status: "REGISTERED"}},
+		{"invalid processing", "2377225624", models.ExtRespOrder{Order: "2377225624", Status: "PROCESSING"}},
+		{"invalid", "2377225624", models.ExtRespOrder{Order: "2377225624", Status: "INVALID"}},
+	}
+
+	for _, tt := range tests {
+		got, err := c.GetExtOrderAccruel(context.Background(), tt.order)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tt.name, err)
+		}
+
+		if got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetExtOrderAccruelTooManyRequests(t *testing.T) {
+	// every third request starts a burst of two 429 answers
+	c, _, cb := newStubController(t, accrualstub.Config{
+		DefaultGoods: []accrualstub.Good{{Description: "default"}},
+		BurstEvery:   3,
+		BurstLength:  2,
+		RetryAfter:   0,
+	})
+
+	throttled := 0
+
+	for i := 0; i < 6; i++ {
+		_, err := c.GetExtOrderAccruel(context.Background(), "12345678903")
+
+		var errThrottled *TooManyRequestsError
+		switch {
+		case errors.As(err, &errThrottled):
+			throttled++
+
+			if errThrottled.RetryAfter != 0 {
+				t.Errorf("got retry after %s, want 0", errThrottled.RetryAfter)
+			}
+		case err != nil:
+			t.Fatalf("request %d: unexpected error %v", i, err)
+		}
+	}
+
+	if throttled != 4 {
+		t.Errorf("got %d throttled requests, want 4", throttled)
+	}
+
+	// throttling is not a failure of the accrual system
+	if state := cb.State(); state != breaker.StateClosed {
+		t.Errorf("got breaker state %s, want %s", state, breaker.StateClosed)
+	}
+}
+
+func TestGetExtOrderAccruelLatency(t *testing.T) {
+	c, _, cb := newStubController(t, accrualstub.Config{
+		DefaultGoods: []accrualstub.Good{{Description: "default"}},
+		LatencyMS:    200,
+	})
+
+	// the answer within the timeout is returned
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	got, err := c.GetExtOrderAccruel(ctx, "12345678903")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if got.Status != "PROCESSED" {
+		t.Errorf("got status %s, want PROCESSED", got.Status)
+	}
+
+	// the requests canceled by the timeout do not open the breaker
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+
+		_, err = c.GetExtOrderAccruel(ctx, "12345678903")
+		cancel()
+
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+		}
+	}
+
+	if state := cb.State(); state != breaker.StateClosed {
+		t.Errorf("got breaker state %s, want %s", state, breaker.StateClosed)
+	}
+}
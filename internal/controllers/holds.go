@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/wurt83ow/gophermart/internal/models"
+	"github.com/wurt83ow/gophermart/internal/storage"
+	"go.uber.org/zap"
+)
+
+// CreateHold reserves the points for the order until they are captured
+// or released, the hold is released automatically after the hold timeout.
+func (h *BaseController) CreateHold(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	metod := zap.String("method", r.Method)
+
+	userID, ok := r.Context().Value(keyUserID).(string)
+	if !ok || userID == "" {
+		// user is not authenticated
+		w.WriteHeader(http.StatusUnauthorized) //code 401
+		h.log.Info("user is not authenticated, request status 401: ", metod)
+		return
+	}
+
+	req := models.RequestHold{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest) //code 400
+		h.log.Info("cannot decode request JSON body: ", zap.Error(err))
+		return
+	}
+
+	ord, err := strconv.Atoi(req.Order)
+	if err != nil || !h.valid(ord) || req.Sum <= 0 {
+		// incorrect order number format or sum
+		w.WriteHeader(http.StatusUnprocessableEntity) //code 422
+		h.log.Info("incorrect hold order number or sum, request status 422: ", metod)
+		return
+	}
+
+	hold, err := h.storage.CreateHold(models.DataHold{
+		UserID: userID,
+		Order:  req.Order,
+		Sum:    req.Sum,
+	}, h.holdTimeout)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrInsufficient):
+			w.WriteHeader(http.StatusPaymentRequired) //code 402
+			h.log.Info("there are insufficient funds in the account, request status 402: ", metod)
+		case errors.Is(err, storage.ErrConflict):
+			// the order is already held or withdrawn
+			w.WriteHeader(http.StatusConflict) //code 409
+			h.log.Info("hold conflicts with a previous one, request status 409: ", metod)
+		case errors.Is(err, storage.ErrUnavailable):
+			// the points are kept only in the database
+			w.WriteHeader(http.StatusServiceUnavailable) //code 503
+			h.log.Info("holds are not available, request status 503: ", metod)
+		default:
+			w.WriteHeader(http.StatusInternalServerError) //code 500
+			h.log.Info("internal server error, request status 500: ", zap.Error(err))
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated) //code 201
+	h.writeHold(w, hold)
+}
+
+// CaptureHold withdraws the held points.
+func (h *BaseController) CaptureHold(w http.ResponseWriter, r *http.Request) {
+	h.finishHold(w, r, h.storage.CaptureHold)
+}
+
+// ReleaseHold returns the held points to the available balance.
+func (h *BaseController) ReleaseHold(w http.ResponseWriter, r *http.Request) {
+	h.finishHold(w, r, h.storage.ReleaseHold)
+}
+
+func (h *BaseController) finishHold(w http.ResponseWriter, r *http.Request,
+	finish func(string, string) (models.DataHold, error),
+) {
+	w.Header().Set("Content-Type", "application/json")
+	metod := zap.String("method", r.Method)
+
+	userID, ok := r.Context().Value(keyUserID).(string)
+	if !ok || userID == "" {
+		// user is not authenticated
+		w.WriteHeader(http.StatusUnauthorized) //code 401
+		h.log.Info("user is not authenticated, request status 401: ", metod)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	hold, err := finish(userID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			w.WriteHeader(http.StatusNotFound) //code 404
+			h.log.Info("hold not found, request status 404: ", zap.String("hold", id))
+		case errors.Is(err, storage.ErrExpired):
+			w.WriteHeader(http.StatusGone) //code 410
+			h.log.Info("hold expired, request status 410: ", zap.String("hold", id))
+		case errors.Is(err, storage.ErrConflict):
+			// the hold is already finished otherwise
+			w.WriteHeader(http.StatusConflict) //code 409
+			h.log.Info("hold is already finished, request status 409: ", zap.String("hold", id))
+		case errors.Is(err, storage.ErrInsufficient):
+			w.WriteHeader(http.StatusPaymentRequired) //code 402
+			h.log.Info("there are insufficient funds in the account, request status 402: ", metod)
+		default:
+			w.WriteHeader(http.StatusInternalServerError) //code 500
+			h.log.Info("internal server error, request status 500: ", zap.Error(err))
+		}
+		return
+	}
+
+	h.writeHold(w, hold)
+}
+
+func (h *BaseController) writeHold(w http.ResponseWriter, hold models.DataHold) {
+	// serialize the server response
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(hold); err != nil {
+		// Internal Server Error
+		w.WriteHeader(http.StatusInternalServerError) //code 500
+		h.log.Info("Internal Server Error: ", zap.Error(err))
+		return
+	}
+}
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/wurt83ow/gophermart/internal/models"
+	"github.com/wurt83ow/gophermart/internal/storage"
+	"go.uber.org/zap"
+)
+
+// limits of a page of the order listing, the default one is used
+// when the next page is requested without the limit.
+const (
+	defaultOrdersLimit = 100
+	maxOrdersLimit     = 1000
+)
+
+// orderStatuses are the statuses of the orders shown to the user.
+var orderStatuses = map[string]bool{
+	"NEW":        true,
+	"PROCESSING": true,
+	"INVALID":    true,
+	"PROCESSED":  true,
+}
+
+// parseOrderFilter reads the query parameters of the order listing: limit,
+// cursor of the next page, from and to dates and status, which may be repeated.
+// Without the limit and the cursor all the orders are listed as before.
+func parseOrderFilter(r *http.Request) (models.OrderFilter, error) {
+	query := r.URL.Query()
+	filter := models.OrderFilter{}
+
+	if query.Get("cursor") != "" {
+		filter.Limit = defaultOrdersLimit
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxOrdersLimit {
+			return filter, errors.New("invalid limit")
+		}
+
+		filter.Limit = limit
+	}
+
+	if v := query.Get("cursor"); v != "" {
+		date, number, err := decodeCursor(v)
+		if err != nil {
+			return filter, err
+		}
+
+		filter.CursorDate, filter.CursorNumber = date, number
+	}
+
+	var err error
+
+	if filter.From, err = parseDate(query.Get("from"), false); err != nil {
+		return filter, err
+	}
+
+	if filter.To, err = parseDate(query.Get("to"), true); err != nil {
+		return filter, err
+	}
+
+	for _, v := range query["status"] {
+		for _, s := range strings.Split(v, ",") {
+			s = strings.ToUpper(s)
+			if !orderStatuses[s] {
+				return filter, errors.New("unknown order status " + s)
+			}
+
+			filter.Statuses = append(filter.Statuses, s)
+		}
+	}
+
+	return filter, nil
+}
+
+// GetUserOrder returns the order of the user with the history of its statuses
+// and the withdrawals which spent the points accrued for it.
+func (h *BaseController) GetUserOrder(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	metod := zap.String("method", r.Method)
+
+	userID, ok := r.Context().Value(keyUserID).(string)
+	if !ok || len(userID) == 0 {
+		// user is not authorized
+		w.WriteHeader(http.StatusUnauthorized) //401
+		h.log.Info("user is not authenticated, request status 401: ", metod)
+		return
+	}
+
+	number := chi.URLParam(r, "number")
+
+	order, err := h.storage.GetUserOrder(userID, number)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound) //code 404
+			h.log.Info("order not found, request status 404: ", metod, zap.String("order", number))
+		} else {
+			w.WriteHeader(http.StatusInternalServerError) //code 500
+			h.log.Info("Internal Server Error: ", zap.Error(err))
+		}
+		return
+	}
+
+	// serialize the server response
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(order); err != nil {
+		// Internal Server Error
+		w.WriteHeader(http.StatusInternalServerError) //code 500
+		h.log.Info("Internal Server Error: ", zap.Error(err))
+		return
+	}
+}
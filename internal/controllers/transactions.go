@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wurt83ow/gophermart/internal/models"
+	"go.uber.org/zap"
+)
+
+// limits of a page of the transaction history.
+const (
+	defaultTransactionsLimit = 50
+	maxTransactionsLimit     = 100
+)
+
+// transactionTypes are the kinds of the ledger entries shown in the history.
+var transactionTypes = map[string]bool{
+	"accrual":         true,
+	"withdrawal":      true,
+	"reversal":        true,
+	"debt_settlement": true,
+	"expiry":          true,
+	"transfer":        true,
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// GetUserTransactions returns the history of the user points from the newest
+// transaction. The query parameters are limit, cursor of the next page,
+// from and to dates in RFC3339 or YYYY-MM-DD and type, which may be repeated.
+// The cursor of the next page is returned in the X-Next-Cursor header.
+func (h *BaseController) GetUserTransactions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	metod := zap.String("method", r.Method)
+
+	userID, ok := r.Context().Value(keyUserID).(string)
+	if !ok || len(userID) == 0 {
+		// user is not authorized
+		w.WriteHeader(http.StatusUnauthorized) //401
+		h.log.Info("user is not authenticated, request status 401: ", metod)
+		return
+	}
+
+	filter, err := parseTransactionFilter(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest) //code 400
+		h.log.Info("invalid transactions query, request status 400: ", metod, zap.Error(err))
+		return
+	}
+
+	// one more transaction is requested to know whether there is a next page
+	limit := filter.Limit
+	filter.Limit++
+
+	transactions, err := h.storage.GetUserTransactions(userID, filter)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError) //code 500
+		h.log.Info("Internal Server Error: ", zap.Error(err))
+		return
+	}
+
+	if len(transactions) == 0 {
+		// no information to answer
+		w.WriteHeader(http.StatusNoContent) // 204
+		h.log.Info("no information to answer, request status 204: ", metod)
+		return
+	}
+
+	// the cursor of the next page is sent in the header like for the orders
+	if len(transactions) > limit {
+		transactions = transactions[:limit]
+		last := transactions[limit-1]
+		w.Header().Set("X-Next-Cursor", encodeCursor(last.Date, last.ID))
+	}
+
+	// serialize the server response
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(transactions); err != nil {
+		// Internal Server Error
+		w.WriteHeader(http.StatusInternalServerError) //code 500
+		h.log.Info("Internal Server Error: ", zap.Error(err))
+		return
+	}
+}
+
+func parseTransactionFilter(r *http.Request) (models.TransactionFilter, error) {
+	query := r.URL.Query()
+	filter := models.TransactionFilter{Limit: defaultTransactionsLimit}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxTransactionsLimit {
+			return filter, errors.New("invalid limit")
+		}
+
+		filter.Limit = limit
+	}
+
+	if v := query.Get("cursor"); v != "" {
+		date, id, err := decodeCursor(v)
+		if err != nil {
+			return filter, err
+		}
+
+		filter.CursorDate, filter.CursorID = date, id
+	}
+
+	var err error
+
+	if filter.From, err = parseDate(query.Get("from"), false); err != nil {
+		return filter, err
+	}
+
+	if filter.To, err = parseDate(query.Get("to"), true); err != nil {
+		return filter, err
+	}
+
+	for _, v := range query["type"] {
+		for _, t := range strings.Split(v, ",") {
+			if !transactionTypes[t] {
+				return filter, errors.New("unknown transaction type " + t)
+			}
+
+			filter.Types = append(filter.Types, t)
+		}
+	}
+
+	return filter, nil
+}
+
+// parseDate parses the date of the query, an empty date is the zero time.
+// The to bound is exclusive, so a date without time as the end
+// of the period is moved to the next day to include the whole day.
+func parseDate(v string, end bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	if date, err := time.Parse(time.RFC3339, v); err == nil {
+		return date, nil
+	}
+
+	date, err := time.Parse(time.DateOnly, v)
+	if err != nil || !end {
+		return date, err
+	}
+
+	return date.AddDate(0, 0, 1), nil
+}
+
+// encodeCursor makes an opaque cursor of the last transaction of the page.
+func encodeCursor(date time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(date.Format(time.RFC3339Nano) + "|" + id))
+}
+
+func decodeCursor(cursor string) (time.Time, string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", errInvalidCursor
+	}
+
+	date, id, found := strings.Cut(string(data), "|")
+	if !found || id == "" {
+		return time.Time{}, "", errInvalidCursor
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, date)
+	if err != nil {
+		return time.Time{}, "", errInvalidCursor
+	}
+
+	return t, id, nil
+}
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/wurt83ow/gophermart/internal/models"
+	"github.com/wurt83ow/gophermart/internal/storage"
+	"go.uber.org/zap"
+)
+
+// Transfer moves the points of the user to the user with the recipient login.
+func (h *BaseController) Transfer(w http.ResponseWriter, r *http.Request) {
+	metod := zap.String("method", r.Method)
+
+	userID, ok := r.Context().Value(keyUserID).(string)
+	if !ok || userID == "" {
+		// user is not authenticated
+		w.WriteHeader(http.StatusUnauthorized) //code 401
+		h.log.Info("user is not authenticated, request status 401: ", metod)
+		return
+	}
+
+	req := models.RequestTransfer{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest) //code 400
+		h.log.Info("cannot decode request JSON body: ", zap.Error(err))
+		return
+	}
+
+	if req.Sum <= 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity) //code 422
+		h.log.Info("incorrect transfer sum, request status 422: ", metod)
+		return
+	}
+
+	recipient, err := h.storage.FindUser(req.Login)
+	if errors.Is(err, storage.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound) //code 404
+		h.log.Info("recipient not found, request status 404: ", metod, zap.String("login", req.Login))
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError) //code 500
+		h.log.Info("internal server error, request status 500: ", zap.Error(err))
+		return
+	}
+
+	if recipient.UUID == userID {
+		w.WriteHeader(http.StatusUnprocessableEntity) //code 422
+		h.log.Info("transfer to the same user, request status 422: ", metod)
+		return
+	}
+
+	req.UserID = userID
+	req.RecipientID = recipient.UUID
+	req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+
+	// a retry of the same transfer returns the original result
+	err = h.storage.Transfer(req)
+	if err != nil {
+		if errors.Is(err, storage.ErrInsufficient) {
+			w.WriteHeader(http.StatusPaymentRequired) //code 402
+			h.log.Info("there are insufficient funds in the account, request status 402: ", metod)
+		} else if errors.Is(err, storage.ErrConflict) {
+			// the key is used by another transfer
+			w.WriteHeader(http.StatusConflict) //code 409
+			h.log.Info("transfer conflicts with a previous one, request status 409: ", metod)
+		} else if errors.Is(err, storage.ErrUnavailable) {
+			// the points are kept only in the database
+			w.WriteHeader(http.StatusServiceUnavailable) //code 503
+			h.log.Info("transfers are not available, request status 503: ", metod)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError) //code 500
+			h.log.Info("internal server error, request status 500: ", zap.Error(err))
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK) //code 200
+}
+
+func (h *BaseController) GetUserTransfers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	metod := zap.String("method", r.Method)
+
+	userID, ok := r.Context().Value(keyUserID).(string)
+	if !ok || len(userID) == 0 {
+		// user is not authorized
+		w.WriteHeader(http.StatusUnauthorized) //401
+		h.log.Info("user is not authenticated, request status 401: ", metod)
+		return
+	}
+
+	transfers, err := h.storage.GetUserTransfers(userID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError) //code 500
+		h.log.Info("Internal Server Error: ", zap.Error(err))
+		return
+	}
+
+	if len(transfers) == 0 {
+		// no information to answer
+		w.WriteHeader(http.StatusNoContent) // 204
+		h.log.Info("no information to answer, request status 204: ", metod)
+		return
+	}
+
+	// serialize the server response
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(transfers); err != nil {
+		// Internal Server Error
+		w.WriteHeader(http.StatusInternalServerError) //code 500
+		h.log.Info("Internal Server Error: ", zap.Error(err))
+		return
+	}
+}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/wurt83ow/gophermart/internal/models"
+	"github.com/wurt83ow/gophermart/internal/storage"
+	"go.uber.org/zap"
+)
+
+// maxWebhookBody limits the size of a pushed update.
+const maxWebhookBody = 1 << 20
+
+// webhookTolerance limits the age of a signed update, older updates are replays.
+const webhookTolerance = 5 * time.Minute
+
+type Pusher interface {
+	PushOrder(models.ExtRespOrder) error
+}
+
+// WebhookController accepts order updates pushed by the accrual system.
+type WebhookController struct {
+	pusher Pusher
+	secret []byte
+	log    Log
+}
+
+func NewWebhookController(pusher Pusher, secret string, log Log) *WebhookController {
+	return &WebhookController{
+		pusher: pusher,
+		secret: []byte(secret),
+		log:    log,
+	}
+}
+
+func (h *WebhookController) Route() *chi.Mux {
+	r := chi.NewRouter()
+
+	r.Post("/webhook", h.PushOrder)
+
+	return r
+}
+
+// PushOrder applies an update signed with the HMAC-SHA256 passed in the X-Signature
+// header. The signed message is the unix time of the X-Timestamp header, a dot
+// and the request body, updates signed too long ago are rejected.
+func (h *WebhookController) PushOrder(w http.ResponseWriter, r *http.Request) {
+	metod := zap.String("method", r.Method)
+
+	if len(h.secret) == 0 {
+		// webhook is disabled
+		w.WriteHeader(http.StatusForbidden) //code 403
+		h.log.Info("webhook secret is not configured, request status 403: ", metod)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil || len(body) == 0 {
+		// invalid request format
+		w.WriteHeader(http.StatusBadRequest) //code 400
+		h.log.Info("invalid request format, request status 400: ", metod)
+		return
+	}
+
+	timestamp := r.Header.Get("X-Timestamp")
+	if !h.freshTimestamp(timestamp) {
+		// request is signed too long ago or replayed
+		w.WriteHeader(http.StatusUnauthorized) //code 401
+		h.log.Info("stale webhook timestamp, request status 401: ", metod, zap.String("timestamp", timestamp))
+		return
+	}
+
+	if !h.validSignature(timestamp, body, r.Header.Get("X-Signature")) {
+		// request is not signed by the accrual system
+		w.WriteHeader(http.StatusUnauthorized) //code 401
+		h.log.Info("invalid webhook signature, request status 401: ", metod)
+		return
+	}
+
+	order := models.ExtRespOrder{}
+	if err := json.Unmarshal(body, &order); err != nil || order.Order == "" {
+		w.WriteHeader(http.StatusBadRequest) //code 400
+		h.log.Info("cannot decode request JSON body: ", zap.Error(err))
+		return
+	}
+
+	err = h.pusher.PushOrder(order)
+	if err != nil {
+		if errors.Is(err, ErrUnknownAccrualStatus) {
+			w.WriteHeader(http.StatusUnprocessableEntity) //code 422
+			h.log.Info("unknown accrual status, request status 422: ", zap.String("status", order.Status))
+		} else if errors.Is(err, storage.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound) //code 404
+			h.log.Info("unknown order, request status 404: ", zap.String("order", order.Order))
+		} else {
+			w.WriteHeader(http.StatusInternalServerError) //code 500
+			h.log.Info("internal server error, request status 500: ", zap.Error(err))
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK) //code 200
+}
+
+func (h *WebhookController) freshTimestamp(timestamp string) bool {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	age := time.Since(time.Unix(sec, 0))
+
+	return age < webhookTolerance && age > -webhookTolerance
+}
+
+func (h *WebhookController) validSignature(timestamp string, body []byte, signature string) bool {
+	sign, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return hmac.Equal(sign, mac.Sum(nil))
+}
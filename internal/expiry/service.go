@@ -0,0 +1,151 @@
+package expiry
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wurt83ow/gophermart/internal/models"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// expiryInterval is the interval between the runs of the points expiry job.
+const expiryInterval = time.Hour
+
+// holdsInterval is the interval between the runs of the holds expiry job.
+const holdsInterval = time.Minute
+
+type Log interface {
+	Info(string, ...zapcore.Field)
+}
+
+type Storage interface {
+	ExpirePoints(time.Duration) (models.Money, error)
+	GetExpiringPoints(string, time.Duration) (models.Money, error)
+	ExpireHolds() (int, error)
+}
+
+// ExpiryService expires the points which are not spent during their lifetime
+// and releases the holds which are not finished in time.
+type ExpiryService struct {
+	wg         sync.WaitGroup
+	cancelFunc context.CancelFunc
+	storage    Storage
+	log        Log
+	lifetime   time.Duration
+	window     time.Duration
+}
+
+func NewExpiryService(storage Storage, log Log, lifetime func() string,
+	expiringWindow func() string,
+) *ExpiryService {
+	hours, err := strconv.Atoi(lifetime())
+	if err != nil {
+		log.Info("cannot convert points lifetime option: ", zap.Error(err))
+
+		hours = 0
+	}
+
+	window, err := strconv.Atoi(expiringWindow())
+	if err != nil {
+		log.Info("cannot convert expiring points window option: ", zap.Error(err))
+
+		window = 168
+	}
+
+	return &ExpiryService{
+		wg:         sync.WaitGroup{},
+		cancelFunc: nil,
+		storage:    storage,
+		log:        log,
+		lifetime:   time.Duration(hours) * time.Hour,
+		window:     time.Duration(window) * time.Hour,
+	}
+}
+
+// Start runs the expiry jobs in the background, the points
+// never expire when the lifetime is not configured.
+func (e *ExpiryService) Start() {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	e.cancelFunc = cancelFunc
+
+	if e.lifetime > 0 {
+		e.wg.Add(1)
+
+		go func() {
+			defer e.wg.Done()
+			e.ExpirePoints(ctx)
+		}()
+	}
+
+	e.wg.Add(1)
+
+	go func() {
+		defer e.wg.Done()
+		e.ExpireHolds(ctx)
+	}()
+}
+
+// Stop stops the expiry jobs and waits for the current runs to finish.
+func (e *ExpiryService) Stop() {
+	if e.cancelFunc != nil {
+		e.cancelFunc()
+	}
+
+	e.wg.Wait()
+}
+
+// ExpirePoints writes off the points accrued earlier than their lifetime
+// once in the expiry interval.
+func (e *ExpiryService) ExpirePoints(ctx context.Context) {
+	t := time.NewTicker(expiryInterval)
+	defer t.Stop()
+
+	for {
+		expired, err := e.storage.ExpirePoints(e.lifetime)
+		if err != nil {
+			e.log.Info("cannot expire points: ", zap.Error(err))
+		} else if expired != 0 {
+			e.log.Info("points expired: ", zap.String("sum", expired.String()))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// ExpireHolds releases the expired holds once in the holds interval.
+func (e *ExpiryService) ExpireHolds(ctx context.Context) {
+	t := time.NewTicker(holdsInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		expired, err := e.storage.ExpireHolds()
+		if err != nil {
+			e.log.Info("cannot expire holds: ", zap.Error(err))
+		} else if expired != 0 {
+			e.log.Info("holds expired: ", zap.Int("count", expired))
+		}
+	}
+}
+
+// ExpiringSoon returns the points of the user which expire within the window.
+func (e *ExpiryService) ExpiringSoon(userID string) (models.Money, error) {
+	if e.lifetime <= 0 {
+		return 0, nil
+	}
+
+	// the age is counted by the database clock the lots are dated with
+	return e.storage.GetExpiringPoints(userID, e.lifetime-e.window)
+}
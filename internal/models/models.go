@@ -10,9 +10,10 @@ type DataOrder struct {
 	Status      string    `db:"status" json:"status"`
 	Date        time.Time `db:"date" json:"-"`
 	DateRFC     string    `db:"date_rfc" json:"uploaded_at"`
-	Accrual     float32   `db:"accrual" json:"accrual,omitempty"`
+	Accrual     Money     `db:"accrual" json:"accrual,omitempty"`
 	UserID      string    `db:"user_id" json:"-"`
-	UserAccrual float32   `db:"user_accrual" json:"user_accrual,omitempty"`
+	UserAccrual Money     `db:"user_accrual" json:"user_accrual,omitempty"`
+	Attempts    int       `db:"attempts" json:"-"`
 }
 
 type DataUser struct {
@@ -23,8 +24,17 @@ type DataUser struct {
 }
 
 type DataBalance struct {
-	Current   float32 `db:"current" json:"current"`
-	Withdrawn float32 `db:"withdrawn" json:"withdrawn"`
+	Current      Money `db:"current" json:"current"`
+	Available    Money `db:"available" json:"available"`
+	Held         Money `db:"held" json:"held"`
+	Withdrawn    Money `db:"withdrawn" json:"withdrawn"`
+	Debt         Money `db:"debt" json:"debt,omitempty"`
+	ExpiringSoon Money `db:"expiring_soon" json:"expiring_soon,omitempty"`
+}
+
+type DataHealth struct {
+	Database string `json:"database"`
+	Accrual  string `json:"accrual"`
 }
 
 type RequestUser struct {
@@ -32,20 +42,144 @@ type RequestUser struct {
 	Password string `json:"password"`
 }
 
+type RequestPoolSize struct {
+	Workers int `json:"workers"`
+}
+
+type RequestReversal struct {
+	Policy string `json:"policy"`
+}
+
+type RequestHold struct {
+	Order string `json:"order"`
+	Sum   Money  `json:"sum"`
+}
+
+type RequestTransfer struct {
+	Login          string `json:"login"`
+	Sum            Money  `json:"sum"`
+	UserID         string `json:"-"`
+	RecipientID    string `json:"-"`
+	IdempotencyKey string `json:"-"`
+}
+
 type ResponseUser struct {
 	Response string `json:"response,omitempty"`
 }
 
 type ExtRespOrder struct {
-	Order   string  `db:"order" json:"order"`
-	Status  string  `db:"status" json:"status"`
-	Accrual float32 `db:"accrual" json:"accrual,omitempty"`
+	Order   string `db:"order" json:"order"`
+	Status  string `db:"status" json:"status"`
+	Accrual Money  `db:"accrual" json:"accrual,omitempty"`
 }
 
 type DataWithdraw struct {
-	UserID  string    `db:"user_id" json:"user_id"`
-	Order   string    `db:"order" json:"order"`
-	Sum     float32   `db:"sum" json:"sum"`
-	Date    time.Time `db:"date" json:"-"`
+	UserID         string    `db:"user_id" json:"user_id"`
+	Order          string    `db:"order" json:"order"`
+	Sum            Money     `db:"sum" json:"sum"`
+	Date           time.Time `db:"date" json:"-"`
+	DateRFC        string    `db:"processed_at" json:"processed_at"`
+	IdempotencyKey string    `db:"idempotency_key" json:"-"`
+}
+
+type DataHold struct {
+	ID         string    `db:"hold_id" json:"id"`
+	UserID     string    `db:"user_id" json:"-"`
+	Order      string    `db:"number" json:"order"`
+	Sum        Money     `db:"sum" json:"sum"`
+	Status     string    `db:"status" json:"status"`
+	ExpiresAt  time.Time `db:"expires_at" json:"-"`
+	ExpiresRFC string    `db:"expires_rfc" json:"expires_at"`
+}
+
+// statuses of the points holds.
+const (
+	HoldHeld     = "HELD"
+	HoldCaptured = "CAPTURED"
+	HoldReleased = "RELEASED"
+	HoldExpired  = "EXPIRED"
+)
+
+type DataTransfer struct {
+	Login     string    `db:"login" json:"login"`
+	Direction string    `db:"direction" json:"direction"`
+	Sum       Money     `db:"sum" json:"sum"`
+	Date      time.Time `db:"date" json:"-"`
+	DateRFC   string    `db:"processed_at" json:"processed_at"`
+}
+
+// directions of the points transfers.
+const (
+	TransferIn  = "in"
+	TransferOut = "out"
+)
+
+type DataTransaction struct {
+	ID      string    `db:"entry_id" json:"id"`
+	Type    string    `db:"kind" json:"type"`
+	Order   string    `db:"reference" json:"order,omitempty"`
+	Amount  Money     `db:"amount" json:"amount"`
+	Balance Money     `db:"balance" json:"balance"`
+	Date    time.Time `db:"created_at" json:"-"`
 	DateRFC string    `db:"processed_at" json:"processed_at"`
 }
+
+// TransactionFilter selects a page of the user transactions, the page
+// starts after the transaction of the cursor.
+type TransactionFilter struct {
+	From       time.Time
+	To         time.Time
+	Types      []string
+	CursorDate time.Time
+	CursorID   string
+	Limit      int
+}
+
+type DataOrderDetail struct {
+	DataOrder
+	History     []DataOrderStatus `json:"history"`
+	Withdrawals []DataWithdraw    `json:"withdrawals"`
+}
+
+type DataOrderStatus struct {
+	Status  string    `db:"status" json:"status"`
+	Date    time.Time `db:"changed_at" json:"-"`
+	DateRFC string    `db:"changed_rfc" json:"changed_at"`
+}
+
+// OrderFilter selects a page of the user orders, the page
+// starts after the order of the cursor, a zero limit selects all the orders.
+type OrderFilter struct {
+	From         time.Time
+	To           time.Time
+	Statuses     []string
+	CursorDate   time.Time
+	CursorNumber string
+	Limit        int
+}
+
+// PollSchedule describes how open orders are polled in the accrual system.
+type PollSchedule struct {
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Lease      time.Duration
+	PushWindow time.Duration
+}
+
+// policies of the accrual reversal when the user has already spent the points.
+const (
+	// ReversalNegative reverses the whole accrual, the balance may become negative.
+	ReversalNegative = "negative"
+	// ReversalClawback takes back what is left on the balance, the rest becomes a debt
+	// which is settled by the next accruals.
+	ReversalClawback = "clawback"
+)
+
+// DataReversal describes the reversed accrual of an order.
+type DataReversal struct {
+	Order    string `db:"order" json:"order"`
+	Policy   string `db:"policy" json:"policy"`
+	Accrual  Money  `db:"accrual" json:"accrual"`
+	Reversed Money  `db:"reversed" json:"reversed"`
+	Debt     Money  `db:"debt" json:"debt"`
+}
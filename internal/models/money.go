@@ -0,0 +1,123 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// moneyScale is the number of minor units in one point.
+const moneyScale = 100
+
+// ErrInvalidMoney indicates that a value can't be read as an amount of money.
+var ErrInvalidMoney = errors.New("invalid money amount")
+
+// Money is an exact amount of loyalty points in minor units (hundredths).
+// It is encoded in JSON and SQL as a decimal number.
+type Money int64
+
+// ParseMoney reads a decimal number, digits after the second
+// fractional one are rounded half away from zero.
+func ParseMoney(s string) (Money, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+
+	r.Mul(r, big.NewRat(moneyScale, 1))
+
+	// round half away from zero
+	num := new(big.Int).Abs(r.Num())
+	q, m := new(big.Int).QuoRem(num, r.Denom(), new(big.Int))
+	if m.Mul(m, big.NewInt(2)).Cmp(r.Denom()) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+
+	if !q.IsInt64() {
+		return 0, fmt.Errorf("%w: %q is out of range", ErrInvalidMoney, s)
+	}
+
+	if r.Sign() < 0 {
+		return Money(-q.Int64()), nil
+	}
+
+	return Money(q.Int64()), nil
+}
+
+// String formats the amount as a decimal number without trailing zeros.
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+
+	s := sign + strconv.FormatInt(v/moneyScale, 10)
+
+	if frac := v % moneyScale; frac != 0 {
+		s += "." + strings.TrimRight(fmt.Sprintf("%02d", frac), "0")
+	}
+
+	return s
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "null" {
+		return nil
+	}
+
+	v, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+
+	*m = v
+
+	return nil
+}
+
+// Value passes the amount to the database as a decimal string.
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// Scan reads the amount from a numeric database column, NULL is read as zero.
+func (m *Money) Scan(src interface{}) error {
+	var (
+		v   Money
+		err error
+	)
+
+	switch s := src.(type) {
+	case nil:
+		v = 0
+	case string:
+		v, err = ParseMoney(s)
+	case []byte:
+		v, err = ParseMoney(string(s))
+	case int64:
+		v = Money(s * moneyScale)
+	case float64:
+		v, err = ParseMoney(strconv.FormatFloat(s, 'f', -1, 64))
+	default:
+		err = fmt.Errorf("%w: unsupported type %T", ErrInvalidMoney, src)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	*m = v
+
+	return nil
+}
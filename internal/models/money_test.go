@@ -0,0 +1,167 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{name: "integer", in: "500", want: 50000},
+		{name: "fraction", in: "729.98", want: 72998},
+		{name: "spaces", in: " 1.5 ", want: 150},
+		{name: "round down", in: "1.004", want: 100},
+		{name: "round half up", in: "1.005", want: 101},
+		{name: "round up", in: "0.999", want: 100},
+		{name: "negative", in: "-2.5", want: -250},
+		{name: "negative round half away from zero", in: "-1.005", want: -101},
+		{name: "negative rounded to zero", in: "-0.004", want: 0},
+		{name: "many fractional digits", in: "0.12345678901234567890", want: 12},
+		{name: "too many digits", in: "123456789012345678901234567890", wantErr: true},
+		{name: "empty", in: "", wantErr: true},
+		{name: "letters", in: "abc", wantErr: true},
+		{name: "two points", in: "1.2.3", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMoney(tt.in)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidMoney) {
+					t.Fatalf("ParseMoney(%q) error = %v, want %v", tt.in, err, ErrInvalidMoney)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseMoney(%q) unexpected error %v", tt.in, err)
+			}
+
+			if got != tt.want {
+				t.Errorf("ParseMoney(%q) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		in   Money
+		want string
+	}{
+		{in: 0, want: "0"},
+		{in: 50000, want: "500"},
+		{in: 150, want: "1.5"},
+		{in: 105, want: "1.05"},
+		{in: 5, want: "0.05"},
+		{in: -5, want: "-0.05"},
+		{in: -72998, want: "-729.98"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", int64(tt.in), got, tt.want)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	type payload struct {
+		Sum Money `json:"sum"`
+	}
+
+	tests := []struct {
+		name    string
+		in      string
+		want    Money
+		out     string
+		wantErr bool
+	}{
+		{name: "number", in: `{"sum":729.98}`, want: 72998, out: `{"sum":729.98}`},
+		{name: "integer", in: `{"sum":500}`, want: 50000, out: `{"sum":500}`},
+		{name: "string", in: `{"sum":"1.5"}`, want: 150, out: `{"sum":1.5}`},
+		{name: "negative", in: `{"sum":-0.05}`, want: -5, out: `{"sum":-0.05}`},
+		{name: "rounded", in: `{"sum":0.125}`, want: 13, out: `{"sum":0.13}`},
+		{name: "null", in: `{"sum":null}`, want: 0, out: `{"sum":0}`},
+		{name: "invalid", in: `{"sum":"abc"}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p payload
+
+			err := json.Unmarshal([]byte(tt.in), &p)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidMoney) {
+					t.Fatalf("unmarshal %s error = %v, want %v", tt.in, err, ErrInvalidMoney)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unmarshal %s unexpected error %v", tt.in, err)
+			}
+
+			if p.Sum != tt.want {
+				t.Errorf("unmarshal %s = %d, want %d", tt.in, p.Sum, tt.want)
+			}
+
+			out, err := json.Marshal(p)
+			if err != nil {
+				t.Fatalf("marshal %d unexpected error %v", p.Sum, err)
+			}
+
+			if string(out) != tt.out {
+				t.Errorf("marshal %d = %s, want %s", p.Sum, out, tt.out)
+			}
+		})
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     interface{}
+		want    Money
+		wantErr bool
+	}{
+		{name: "null", src: nil, want: 0},
+		{name: "string", src: "729.98", want: 72998},
+		{name: "bytes", src: []byte("-1.5"), want: -150},
+		{name: "int64", src: int64(500), want: 50000},
+		{name: "float64", src: 0.1 + 0.2, want: 30},
+		{name: "invalid string", src: "abc", wantErr: true},
+		{name: "unsupported type", src: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Money(1)
+
+			err := m.Scan(tt.src)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidMoney) {
+					t.Fatalf("Scan(%v) error = %v, want %v", tt.src, err, ErrInvalidMoney)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Scan(%v) unexpected error %v", tt.src, err)
+			}
+
+			if m != tt.want {
+				t.Errorf("Scan(%v) = %d, want %d", tt.src, m, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// decreaseFactor is applied to the rate when the service throttles us.
+	decreaseFactor = 0.5
+	// recoveryStep is the share of the configured rate
+	// restored after every successful request.
+	recoveryStep = 0.05
+	// minRateShare limits how low the rate may fall.
+	minRateShare = 0.01
+	// observeWindow is the window the rate of an unlimited limiter is observed in.
+	observeWindow = time.Minute
+	// minObservedRate is the lowest rate learned by an unlimited limiter.
+	minObservedRate = 1.0 / 60
+)
+
+type Log interface {
+	Info(string, ...zapcore.Field)
+}
+
+// Limiter is an adaptive token bucket shared by all requests to a service.
+type Limiter struct {
+	mx      sync.Mutex
+	maxRate float64
+	rate    float64
+	tokens  float64
+	last    time.Time
+	log     Log
+
+	// the requests observed while the limiter is unlimited
+	windowStart time.Time
+	windowCount int
+}
+
+// NewLimiter returns a limiter allowing the number of requests per minute.
+// Zero starts unlimited, the first throttling limits the rate to the observed one.
+func NewLimiter(perMinute func() string, log Log) *Limiter {
+	rpm, err := strconv.Atoi(perMinute())
+	if err != nil || rpm < 0 {
+		log.Info("cannot convert rate limit option: ", zap.Error(err))
+		rpm = 0
+	}
+
+	rate := float64(rpm) / 60
+
+	return &Limiter{
+		maxRate: rate,
+		rate:    rate,
+		tokens:  burst(rate),
+		last:    time.Now(),
+		log:     log,
+
+		windowStart: time.Now(),
+	}
+}
+
+// Wait blocks until a request is allowed or the context is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	for {
+		l.mx.Lock()
+
+		if l.maxRate == 0 {
+			l.observe()
+			l.mx.Unlock()
+
+			return nil
+		}
+
+		l.refill()
+
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mx.Unlock()
+
+			return nil
+		}
+
+		d := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.mx.Unlock()
+
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return fmt.Errorf("rate limiter wait canceled: %w", ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// Decrease cuts the rate when the service reports too many requests.
+func (l *Limiter) Decrease() {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	if l.maxRate == 0 {
+		// the rate the service has throttled becomes the maximum rate
+		elapsed := math.Max(time.Since(l.windowStart).Seconds(), 1)
+		l.maxRate = math.Max(float64(l.windowCount)/elapsed, minObservedRate)
+		l.rate = l.maxRate
+		l.last = time.Now()
+	}
+
+	l.refill()
+	l.rate = math.Max(l.rate*decreaseFactor, l.maxRate*minRateShare)
+	l.tokens = 0
+
+	l.log.Info("rate limit decreased: ", zap.Float64("per_minute", l.rate*60))
+}
+
+// Increase gradually restores the rate after a successful request.
+func (l *Limiter) Increase() {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	if l.maxRate != 0 && l.rate < l.maxRate {
+		l.refill()
+		l.rate = math.Min(l.rate+l.maxRate*recoveryStep, l.maxRate)
+	}
+}
+
+// observe counts the request of an unlimited limiter, mx must be held.
+func (l *Limiter) observe() {
+	now := time.Now()
+	if now.Sub(l.windowStart) >= observeWindow {
+		l.windowStart = now
+		l.windowCount = 0
+	}
+
+	l.windowCount++
+}
+
+// refill adds the tokens earned since the last call, mx must be held.
+func (l *Limiter) refill() {
+	now := time.Now()
+	l.tokens = math.Min(burst(l.rate), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+}
+
+// burst lets a second worth of requests through at once.
+func burst(rate float64) float64 {
+	return math.Max(1, rate)
+}
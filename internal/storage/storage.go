@@ -15,6 +15,9 @@ import (
 var (
 	ErrConflict     = errors.New("data conflict")
 	ErrInsufficient = errors.New("insufficient funds")
+	ErrNotFound     = errors.New("not found")
+	ErrExpired      = errors.New("expired")
+	ErrUnavailable  = errors.New("not available without a database")
 )
 
 type (
@@ -40,12 +43,27 @@ type Keeper interface {
 	LoadUsers() (StorageUsers, error)
 	SaveOrder(string, models.DataOrder) (models.DataOrder, error)
 	SaveUser(string, models.DataUser) (models.DataUser, error)
-	GetOpenOrders() ([]string, error)
+	FindUser(string) (models.DataUser, error)
+	GetOpenOrders(models.PollSchedule) ([]models.DataOrder, error)
+	GetUserOrders(string, models.OrderFilter) ([]models.DataOrder, error)
+	GetUserOrder(string, string) (models.DataOrderDetail, error)
 	GetUserBalance(string) (models.DataBalance, error)
 	GetUserWithdrawals(string) ([]models.DataWithdraw, error)
-	UpdateOrderStatus([]models.ExtRespOrder) error
+	UpdateOrderStatus([]models.ExtRespOrder) ([]string, error)
 	InsertAccruel(map[string]models.ExtRespOrder) error
+	SetOrderPushed(string) error
+	HasOrder(string) (bool, error)
 	Withdraw(models.DataWithdraw) error
+	ReverseAccrual(string, string) (models.DataReversal, error)
+	ExpirePoints(time.Duration) (models.Money, error)
+	GetExpiringPoints(string, time.Duration) (models.Money, error)
+	CreateHold(models.DataHold, time.Duration) (models.DataHold, error)
+	CaptureHold(string, string) (models.DataHold, error)
+	ReleaseHold(string, string) (models.DataHold, error)
+	ExpireHolds() (int, error)
+	Transfer(models.RequestTransfer) error
+	GetUserTransfers(string) ([]models.DataTransfer, error)
+	GetUserTransactions(string, models.TransactionFilter) ([]models.DataTransaction, error)
 	Ping() bool
 	Close() bool
 }
@@ -75,29 +93,54 @@ func NewMemoryStorage(keeper Keeper, log Log) *MemoryStorage {
 	}
 }
 
-func (s *MemoryStorage) UpdateOrderStatus(result []models.ExtRespOrder) error {
-	err := s.keeper.UpdateOrderStatus(result)
+// UpdateOrderStatus saves the statuses of the orders, it returns
+// the numbers of the orders whose status has actually changed.
+func (s *MemoryStorage) UpdateOrderStatus(result []models.ExtRespOrder) ([]string, error) {
+	changed, err := s.keeper.UpdateOrderStatus(result)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	s.omx.Lock()
+	defer s.omx.Unlock()
+
 	for _, v := range result {
 		o, exists := s.orders[v.Order]
 
-		if exists {
+		// the final status of an order does not change any more
+		if exists && o.Status != "PROCESSED" && o.Status != "INVALID" {
 			o.Status = v.Status
 			o.Accrual = v.Accrual
 			s.orders[v.Order] = o
 		}
 	}
 
-	return nil
+	return changed, nil
 }
 
 func (s *MemoryStorage) InsertAccruel(orders map[string]models.ExtRespOrder) error {
 	return s.keeper.InsertAccruel(orders)
 }
 
+// HasOrder reports whether the order is uploaded, the keeper knows
+// the orders uploaded to the other instances as well.
+func (s *MemoryStorage) HasOrder(number string) (bool, error) {
+	if s.keeper != nil {
+		return s.keeper.HasOrder(number)
+	}
+
+	s.omx.RLock()
+	defer s.omx.RUnlock()
+
+	_, exists := s.orders[number]
+
+	return exists, nil
+}
+
+func (s *MemoryStorage) SetOrderPushed(number string) error {
+	return s.keeper.SetOrderPushed(number)
+}
+
 func (s *MemoryStorage) GetUser(k string) (models.DataUser, error) {
 	s.umx.RLock()
 	defer s.umx.RUnlock()
@@ -110,8 +153,26 @@ func (s *MemoryStorage) GetUser(k string) (models.DataUser, error) {
 	return v, nil
 }
 
-func (s *MemoryStorage) GetOpenOrders() ([]string, error) {
-	orders, err := s.keeper.GetOpenOrders()
+// FindUser returns the user with the login, the keeper knows
+// the users registered at the other instances as well.
+func (s *MemoryStorage) FindUser(login string) (models.DataUser, error) {
+	if s.keeper != nil {
+		return s.keeper.FindUser(login)
+	}
+
+	s.umx.RLock()
+	defer s.umx.RUnlock()
+
+	v, exists := s.users[login]
+	if !exists {
+		return models.DataUser{}, ErrNotFound
+	}
+
+	return v, nil
+}
+
+func (s *MemoryStorage) GetOpenOrders(schedule models.PollSchedule) ([]models.DataOrder, error) {
+	orders, err := s.keeper.GetOpenOrders(schedule)
 	if err != nil {
 		return nil, err
 	}
@@ -151,14 +212,20 @@ func (s *MemoryStorage) InsertUser(k string,
 	return nv, nil
 }
 
-func (s *MemoryStorage) GetUserOrders(userID string) []models.DataOrder {
+// GetUserOrders returns a page of the user orders from the newest one,
+// the keeper reads them by index, otherwise the stored orders are scanned.
+func (s *MemoryStorage) GetUserOrders(userID string, filter models.OrderFilter) ([]models.DataOrder, error) {
+	if s.keeper != nil {
+		return s.keeper.GetUserOrders(userID, filter)
+	}
+
 	orders := make([]models.DataOrder, 0)
 
 	s.omx.RLock()
 	defer s.omx.RUnlock()
 
 	for _, o := range s.orders {
-		if o.UserID != userID {
+		if o.UserID != userID || !matchOrder(o, filter) {
 			continue
 		}
 		o.DateRFC = o.Date.Format(time.RFC3339)
@@ -166,10 +233,69 @@ func (s *MemoryStorage) GetUserOrders(userID string) []models.DataOrder {
 	}
 
 	sort.SliceStable(orders, func(i, j int) bool {
+		if orders[i].Date.Equal(orders[j].Date) {
+			return orders[i].Number > orders[j].Number
+		}
+
 		return orders[i].Date.After(orders[j].Date)
 	})
 
-	return orders
+	if filter.Limit != 0 && len(orders) > filter.Limit {
+		orders = orders[:filter.Limit]
+	}
+
+	return orders, nil
+}
+
+// GetUserOrder returns the order of the user with its history,
+// the history is known only to the keeper.
+func (s *MemoryStorage) GetUserOrder(userID string, number string) (models.DataOrderDetail, error) {
+	if s.keeper != nil {
+		return s.keeper.GetUserOrder(userID, number)
+	}
+
+	s.omx.RLock()
+	defer s.omx.RUnlock()
+
+	o, exists := s.orders[number]
+	if !exists || o.UserID != userID {
+		return models.DataOrderDetail{}, ErrNotFound
+	}
+
+	o.DateRFC = o.Date.Format(time.RFC3339)
+
+	return models.DataOrderDetail{
+		DataOrder:   o,
+		History:     make([]models.DataOrderStatus, 0),
+		Withdrawals: make([]models.DataWithdraw, 0),
+	}, nil
+}
+
+func matchOrder(o models.DataOrder, filter models.OrderFilter) bool {
+	if !filter.From.IsZero() && o.Date.Before(filter.From) {
+		return false
+	}
+
+	if !filter.To.IsZero() && !o.Date.Before(filter.To) {
+		return false
+	}
+
+	if filter.CursorNumber != "" && (o.Date.After(filter.CursorDate) ||
+		o.Date.Equal(filter.CursorDate) && o.Number >= filter.CursorNumber) {
+		return false
+	}
+
+	if len(filter.Statuses) == 0 {
+		return true
+	}
+
+	for _, status := range filter.Statuses {
+		if o.Status == status {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (s *MemoryStorage) GetUserWithdrawals(userID string) ([]models.DataWithdraw, error) {
@@ -188,6 +314,88 @@ func (s *MemoryStorage) Withdraw(withdraw models.DataWithdraw) error {
 	return s.keeper.Withdraw(withdraw)
 }
 
+func (s *MemoryStorage) ReverseAccrual(number string, policy string) (models.DataReversal, error) {
+	if s.keeper == nil {
+		return models.DataReversal{}, ErrNotFound
+	}
+
+	return s.keeper.ReverseAccrual(number, policy)
+}
+
+func (s *MemoryStorage) ExpirePoints(age time.Duration) (models.Money, error) {
+	if s.keeper == nil {
+		return 0, nil
+	}
+
+	return s.keeper.ExpirePoints(age)
+}
+
+func (s *MemoryStorage) GetExpiringPoints(userID string, age time.Duration) (models.Money, error) {
+	if s.keeper == nil {
+		return 0, nil
+	}
+
+	return s.keeper.GetExpiringPoints(userID, age)
+}
+
+func (s *MemoryStorage) CreateHold(hold models.DataHold, timeout time.Duration) (models.DataHold, error) {
+	if s.keeper == nil {
+		return models.DataHold{}, ErrUnavailable
+	}
+
+	return s.keeper.CreateHold(hold, timeout)
+}
+
+func (s *MemoryStorage) CaptureHold(userID string, holdID string) (models.DataHold, error) {
+	if s.keeper == nil {
+		return models.DataHold{}, ErrNotFound
+	}
+
+	return s.keeper.CaptureHold(userID, holdID)
+}
+
+func (s *MemoryStorage) ReleaseHold(userID string, holdID string) (models.DataHold, error) {
+	if s.keeper == nil {
+		return models.DataHold{}, ErrNotFound
+	}
+
+	return s.keeper.ReleaseHold(userID, holdID)
+}
+
+func (s *MemoryStorage) ExpireHolds() (int, error) {
+	if s.keeper == nil {
+		return 0, nil
+	}
+
+	return s.keeper.ExpireHolds()
+}
+
+func (s *MemoryStorage) Transfer(transfer models.RequestTransfer) error {
+	if s.keeper == nil {
+		return ErrUnavailable
+	}
+
+	return s.keeper.Transfer(transfer)
+}
+
+func (s *MemoryStorage) GetUserTransfers(userID string) ([]models.DataTransfer, error) {
+	if s.keeper == nil {
+		return []models.DataTransfer{}, nil
+	}
+
+	return s.keeper.GetUserTransfers(userID)
+}
+
+func (s *MemoryStorage) GetUserTransactions(userID string,
+	filter models.TransactionFilter,
+) ([]models.DataTransaction, error) {
+	if s.keeper == nil {
+		return []models.DataTransaction{}, nil
+	}
+
+	return s.keeper.GetUserTransactions(userID, filter)
+}
+
 func (s *MemoryStorage) SaveOrder(k string, v models.DataOrder) (models.DataOrder, error) {
 	if s.keeper == nil {
 		return v, nil
@@ -204,6 +412,11 @@ func (s *MemoryStorage) SaveUser(k string, v models.DataUser) (models.DataUser,
 	return s.keeper.SaveUser(k, v)
 }
 
+// HasKeeper reports whether the data is persisted to the database.
+func (s *MemoryStorage) HasKeeper() bool {
+	return s.keeper != nil
+}
+
 func (s *MemoryStorage) GetBaseConnection() bool {
 	if s.keeper == nil {
 		return false
@@ -1,6 +1,8 @@
 package workerpool
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"sync"
@@ -11,12 +13,28 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
+// maxWorkers limits the size of the pool.
+const maxWorkers = 100
+
+// drainCheckInterval is the interval of checking the pool for idleness.
+const drainCheckInterval = 50 * time.Millisecond
+
+// ErrInvalidPoolSize indicates that the requested number of workers is out of range.
+var ErrInvalidPoolSize = errors.New("invalid pool size")
+
+// ErrQueueFull indicates that the task is dropped because the queue is full.
+var ErrQueueFull = errors.New("task queue is full")
+
+// ErrPoolStopped indicates that the task is dropped because the pool is stopped.
+var ErrPoolStopped = errors.New("pool is stopped")
+
 type External interface {
-	GetExtOrderAccruel(string) (models.ExtRespOrder, error)
+	GetExtOrderAccruel(context.Context, string) (models.ExtRespOrder, error)
 }
 
 type Log interface {
 	Info(string, ...zapcore.Field)
+	Debug(string, ...zapcore.Field)
 }
 
 // Pool.
@@ -24,12 +42,23 @@ type Pool struct {
 	Tasks   []*Task
 	Workers []*Worker
 
-	concurrency   int
-	collector     chan *Task
-	runBackground chan bool
-	wg            sync.WaitGroup
-	log           Log
-	taskInterval  int
+	concurrency  int
+	collector    chan *Task
+	wg           sync.WaitGroup
+	log          Log
+	taskInterval int
+	ctx          context.Context
+	cancelFunc   context.CancelFunc
+	pmx          sync.RWMutex
+	policies     map[string]RetryPolicy
+	deadLetters  deadLetters
+	kmx          sync.Mutex
+	inFlight     map[string]struct{}
+	suppressed   int64
+	dropped      int64
+	counters     *counters
+	wmx          sync.Mutex
+	lastWorkerID int
 }
 
 // NewPool initializes a new pool with the given tasks.
@@ -46,20 +75,28 @@ func NewPool(tasks []*Task, concurrency func() string, log Log, TaskExecutionInt
 		conc = 5
 	}
 
+	// the pool context is canceled on stop and cancels all running tasks
+	ctx, cancel := context.WithCancel(context.Background())
+
 	return &Pool{
 		Tasks:        tasks,
 		concurrency:  conc,
 		collector:    make(chan *Task, 1000),
 		log:          log,
 		taskInterval: taskInterval,
+		ctx:          ctx,
+		cancelFunc:   cancel,
+		policies:     make(map[string]RetryPolicy),
+		inFlight:     make(map[string]struct{}),
+		counters:     newCounters(),
 	}
 }
 
 // Starts all the work in the Pool and blocks until it is finished.
 func (p *Pool) Run() {
 	for i := 1; i <= p.concurrency; i++ {
-		worker := NewWorker(p.collector, i)
-		worker.Start(&p.wg)
+		worker := NewWorker(p.collector, i, p.run, p.log)
+		worker.Start(p.ctx, &p.wg)
 	}
 
 	for i := range p.Tasks {
@@ -70,42 +107,225 @@ func (p *Pool) Run() {
 	p.wg.Wait()
 }
 
-// AddTask adds tasks to the pool.
-func (p *Pool) AddTask(task *Task) {
-	p.collector <- task
+// AddTask adds tasks to the pool, a task whose key
+// is already in flight is skipped.
+func (p *Pool) AddTask(task *Task) error {
+	if !p.acquire(task) {
+		return nil
+	}
+
+	return p.enqueue(task)
+}
+
+// enqueue puts the task with the acquired key to the queue. The task is dropped
+// instead of waiting when the queue is full or the pool is stopped,
+// its key is released at once, so the task can be added again later.
+func (p *Pool) enqueue(task *Task) error {
+	err := ErrPoolStopped
+
+	if p.ctx.Err() == nil {
+		select {
+		case p.collector <- task:
+			return nil
+		default:
+			err = ErrQueueFull
+		}
+	}
+
+	p.release(task)
+
+	p.kmx.Lock()
+	p.dropped++
+	p.kmx.Unlock()
+
+	p.log.Info("task dropped: ", zap.String("type", task.Type),
+		zap.String("key", task.Key), zap.Error(err))
+
+	return fmt.Errorf("failed to add task: %w", err)
+}
+
+// Stats returns the current state of the pool.
+func (p *Pool) Stats() Stats {
+	stats := Stats{
+		QueueLength:   len(p.collector),
+		QueueCapacity: cap(p.collector),
+		DeadLetters:   len(p.deadLetters.list()),
+	}
+
+	p.wmx.Lock()
+	stats.Workers = len(p.Workers)
+	p.wmx.Unlock()
+
+	p.counters.fill(&stats)
+	stats.IdleWorkers = stats.Workers - stats.BusyWorkers
+
+	p.kmx.Lock()
+	stats.InFlight = len(p.inFlight)
+	stats.SuppressedDuplicates = p.suppressed
+	stats.Dropped = p.dropped
+	p.kmx.Unlock()
+
+	return stats
+}
+
+// run processes the task by the worker and collects its statistics.
+func (p *Pool) run(ctx context.Context, workerID int, task *Task) {
+	p.counters.start()
+	start := time.Now()
+
+	p.log.Debug("worker processes task: ", zap.Int("worker", workerID),
+		zap.String("type", task.Type), zap.String("key", task.Key))
+	process(ctx, task)
+
+	p.counters.finish(time.Since(start), task.Err)
+	p.complete(task)
+}
+
+// acquire marks the task key as in flight until the task is released,
+// false means the task with the same key is already in the pool.
+func (p *Pool) acquire(task *Task) bool {
+	if task.Key == "" {
+		return true
+	}
+
+	p.kmx.Lock()
+	defer p.kmx.Unlock()
+
+	if _, exists := p.inFlight[task.Key]; exists {
+		p.suppressed++
+
+		return false
+	}
+
+	p.inFlight[task.Key] = struct{}{}
+
+	return true
+}
+
+// release removes the task key from the keys in flight.
+func (p *Pool) release(task *Task) {
+	if task.Key == "" {
+		return
+	}
+
+	p.kmx.Lock()
+	defer p.kmx.Unlock()
+
+	delete(p.inFlight, task.Key)
 }
 
 // RunBackground runs the pool in the background.
 func (p *Pool) RunBackground() {
 	go func() {
+		t := time.NewTicker(time.Duration(p.taskInterval) * time.Millisecond)
+		defer t.Stop()
+
 		for {
-			fmt.Print("⌛ Waiting for tasks to come in ...\n")
-			time.Sleep(time.Duration(p.taskInterval) * time.Millisecond)
+			select {
+			case <-p.ctx.Done():
+				return
+			case <-t.C:
+				stats := p.Stats()
+				p.log.Debug("worker pool stats: ", zap.Int("queue_length", stats.QueueLength),
+					zap.Int("busy_workers", stats.BusyWorkers), zap.Int64("processed", stats.Processed),
+					zap.Int64("failed", stats.Failed))
+			}
 		}
 	}()
 
-	for i := 1; i <= p.concurrency; i++ {
-		worker := NewWorker(p.collector, i)
-		p.Workers = append(p.Workers, worker)
-		go worker.StartBackground()
+	p.wmx.Lock()
+	// the pool stopped before it was run starts no workers
+	if p.ctx.Err() != nil {
+		p.wmx.Unlock()
+
+		return
 	}
+	p.startWorkers(p.concurrency)
+	p.wmx.Unlock()
 
+	// the dropped tasks are logged by the pool
 	for i := range p.Tasks {
-		p.collector <- p.Tasks[i]
+		_ = p.AddTask(p.Tasks[i])
 	}
 
-	p.runBackground = make(chan bool)
-	<-p.runBackground
+	// the pool runs until it is stopped
+	<-p.ctx.Done()
 }
 
-// Stop stops workers running in the background.
+// Stop stops workers running in the background
+// and cancels the tasks in progress.
 func (p *Pool) Stop() {
+	p.cancelFunc()
+
+	p.wmx.Lock()
 	for i := range p.Workers {
 		p.Workers[i].Stop()
 	}
+	p.wmx.Unlock()
+}
+
+// Drain waits until the queue is empty and all workers are idle
+// or the context is done. Tasks waiting for a retry are not awaited.
+func (p *Pool) Drain(ctx context.Context) error {
+	t := time.NewTicker(drainCheckInterval)
+	defer t.Stop()
 
-	// p.cancelFunc()
-	// p.wg.Wait()
+	// a task may be taken from the queue but not yet started,
+	// so the pool must be seen idle twice in a row
+	idle := 0
+
+	for {
+		stats := p.Stats()
+		if stats.QueueLength == 0 && stats.BusyWorkers == 0 {
+			idle++
+		} else {
+			idle = 0
+		}
+
+		if idle == 2 {
+			return nil
+		}
 
-	p.runBackground <- true
-}
\ No newline at end of file
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to drain pool: %w", ctx.Err())
+		case <-t.C:
+		}
+	}
+}
+
+// Resize changes the number of background workers. New workers start at once,
+// removed workers finish their current task before quitting.
+func (p *Pool) Resize(n int) error {
+	if n < 1 || n > maxWorkers {
+		return fmt.Errorf("%w: %d, must be from 1 to %d", ErrInvalidPoolSize, n, maxWorkers)
+	}
+
+	p.wmx.Lock()
+	defer p.wmx.Unlock()
+
+	if n > len(p.Workers) {
+		p.startWorkers(n - len(p.Workers))
+	}
+
+	for len(p.Workers) > n {
+		last := len(p.Workers) - 1
+		p.Workers[last].Stop()
+		p.Workers = p.Workers[:last]
+	}
+
+	p.log.Info("worker pool resized: ", zap.Int("workers", n))
+	p.concurrency = n
+
+	return nil
+}
+
+// startWorkers starts n more background workers, wmx must be held.
+func (p *Pool) startWorkers(n int) {
+	for i := 0; i < n; i++ {
+		p.lastWorkerID++
+		worker := NewWorker(p.collector, p.lastWorkerID, p.run, p.log)
+		p.Workers = append(p.Workers, worker)
+		go worker.StartBackground(p.ctx)
+	}
+}
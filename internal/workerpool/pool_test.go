@@ -0,0 +1,49 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestAddTaskDropsWithoutBlocking(t *testing.T) {
+	option := func(v string) func() string {
+		return func() string { return v }
+	}
+
+	// the pool without workers does not take tasks from the queue
+	p := NewPool(nil, option("1"), zap.NewNop(), option("1000"))
+
+	newTask := func(key string) *Task {
+		task := NewTask("test", func(context.Context, interface{}) error { return nil }, nil, 0)
+		task.Key = key
+
+		return task
+	}
+
+	for i := 0; i < cap(p.collector); i++ {
+		if err := p.AddTask(newTask(strconv.Itoa(i))); err != nil {
+			t.Fatalf("task %d: unexpected error %v", i, err)
+		}
+	}
+
+	if err := p.AddTask(newTask("full")); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("got error %v, want %v", err, ErrQueueFull)
+	}
+
+	// the key of the dropped task is released
+	stats := p.Stats()
+	if stats.InFlight != cap(p.collector) || stats.Dropped != 1 {
+		t.Errorf("got %d in flight and %d dropped tasks, want %d and 1",
+			stats.InFlight, stats.Dropped, cap(p.collector))
+	}
+
+	p.Stop()
+
+	if err := p.AddTask(newTask("stopped")); !errors.Is(err, ErrPoolStopped) {
+		t.Fatalf("got error %v, want %v", err, ErrPoolStopped)
+	}
+}
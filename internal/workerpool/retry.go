@@ -0,0 +1,169 @@
+package workerpool
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// deadLetterSize is the number of failed tasks kept for inspection.
+const deadLetterSize = 1000
+
+// ErrDeadLetterNotFound indicates that there is no dead task with such id.
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// RetryPolicy sets how a failed task of a type is retried.
+// The delay before the n-th retry is Backoff * 2^(n-1).
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+}
+
+// DeadLetter is a task which failed all its attempts.
+type DeadLetter struct {
+	ID       int         `json:"id"`
+	Type     string      `json:"type"`
+	Data     interface{} `json:"data"`
+	Attempts int         `json:"attempts"`
+	Error    string      `json:"error"`
+	FailedAt time.Time   `json:"failed_at"`
+	task     *Task
+}
+
+// deadLetters is a ring of the last failed tasks.
+type deadLetters struct {
+	mx     sync.Mutex
+	items  []DeadLetter
+	nextID int
+}
+
+func (d *deadLetters) add(task *Task) DeadLetter {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	d.nextID++
+	letter := DeadLetter{
+		ID:       d.nextID,
+		Type:     task.Type,
+		Data:     task.Data,
+		Attempts: task.Attempts,
+		Error:    task.Err.Error(),
+		FailedAt: time.Now(),
+		task:     task,
+	}
+
+	// the oldest letter is dropped when the ring is full
+	if len(d.items) >= deadLetterSize {
+		d.items = d.items[1:]
+	}
+
+	d.items = append(d.items, letter)
+
+	return letter
+}
+
+func (d *deadLetters) list() []DeadLetter {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	result := make([]DeadLetter, len(d.items))
+	copy(result, d.items)
+
+	return result
+}
+
+func (d *deadLetters) take(id int) (*Task, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	for i, v := range d.items {
+		if v.ID == id {
+			d.items = append(d.items[:i], d.items[i+1:]...)
+
+			return v.task, nil
+		}
+	}
+
+	return nil, ErrDeadLetterNotFound
+}
+
+// SetRetryPolicy sets the retry policy for tasks of the type.
+// Tasks without a policy are not retried.
+func (p *Pool) SetRetryPolicy(taskType string, policy RetryPolicy) {
+	p.pmx.Lock()
+	defer p.pmx.Unlock()
+
+	p.policies[taskType] = policy
+}
+
+// DeadLetters returns the tasks which failed all their attempts.
+func (p *Pool) DeadLetters() []DeadLetter {
+	return p.deadLetters.list()
+}
+
+// Requeue adds the dead task back to the pool with a fresh number of attempts.
+func (p *Pool) Requeue(id int) error {
+	task, err := p.deadLetters.take(id)
+	if err != nil {
+		return err
+	}
+
+	task.Attempts = 0
+	task.Err = nil
+
+	if err := p.AddTask(task); err != nil {
+		// the task stays in the dead letters to be requeued later
+		task.Err = err
+		p.deadLetters.add(task)
+
+		return err
+	}
+
+	return nil
+}
+
+// complete retries the failed task according to its policy
+// or moves it to the dead letters.
+func (p *Pool) complete(task *Task) {
+	if task.Err == nil {
+		p.release(task)
+
+		return
+	}
+
+	// tasks canceled by the pool stop are not retried
+	if p.ctx.Err() != nil {
+		p.release(task)
+
+		return
+	}
+
+	p.pmx.RLock()
+	policy := p.policies[task.Type]
+	p.pmx.RUnlock()
+
+	if task.Attempts < policy.MaxAttempts {
+		delay := policy.Backoff << (task.Attempts - 1)
+		// the retried task keeps its key in flight
+		time.AfterFunc(delay, func() {
+			if p.ctx.Err() != nil {
+				p.release(task)
+
+				return
+			}
+
+			// the task dropped from the full queue is logged by the pool
+			_ = p.enqueue(task)
+		})
+
+		return
+	}
+
+	p.release(task)
+	letter := p.deadLetters.add(task)
+	p.log.Info("task moved to dead letters: ", zap.Int("id", letter.ID),
+		zap.String("type", letter.Type), zap.Int("attempts", letter.Attempts),
+		zap.String("error", letter.Error))
+}
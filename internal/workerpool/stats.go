@@ -0,0 +1,118 @@
+package workerpool
+
+import (
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the task latency histogram.
+var latencyBuckets = []time.Duration{
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Stats describes the state of the pool.
+type Stats struct {
+	QueueLength          int       `json:"queue_length"`
+	QueueCapacity        int       `json:"queue_capacity"`
+	Workers              int       `json:"workers"`
+	BusyWorkers          int       `json:"busy_workers"`
+	IdleWorkers          int       `json:"idle_workers"`
+	InFlight             int       `json:"in_flight"`
+	Processed            int64     `json:"processed"`
+	Failed               int64     `json:"failed"`
+	SuppressedDuplicates int64     `json:"suppressed_duplicates"`
+	Dropped              int64     `json:"dropped"`
+	DeadLetters          int       `json:"dead_letters"`
+	Latency              Histogram `json:"latency"`
+}
+
+// Histogram is a cumulative histogram of task latencies in seconds.
+type Histogram struct {
+	Buckets []Bucket `json:"buckets"`
+	Count   int64    `json:"count"`
+	Sum     float64  `json:"sum"`
+}
+
+// Bucket counts the tasks which took no more than LE seconds,
+// the last bucket has no upper bound and LE is zero.
+type Bucket struct {
+	LE    float64 `json:"le,omitempty"`
+	Count int64   `json:"count"`
+}
+
+// counters collects the task statistics of the pool.
+type counters struct {
+	mx        sync.Mutex
+	busy      int
+	processed int64
+	failed    int64
+	buckets   []int64
+	sum       time.Duration
+}
+
+func newCounters() *counters {
+	return &counters{buckets: make([]int64, len(latencyBuckets)+1)}
+}
+
+func (c *counters) start() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.busy++
+}
+
+func (c *counters) finish(latency time.Duration, err error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.busy--
+	c.processed++
+	c.sum += latency
+
+	if err != nil {
+		c.failed++
+	}
+
+	i := 0
+	for i < len(latencyBuckets) && latency > latencyBuckets[i] {
+		i++
+	}
+
+	c.buckets[i]++
+}
+
+// fill writes the counters to the stats.
+func (c *counters) fill(stats *Stats) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	stats.BusyWorkers = c.busy
+	stats.Processed = c.processed
+	stats.Failed = c.failed
+
+	stats.Latency = Histogram{
+		Buckets: make([]Bucket, 0, len(c.buckets)),
+		Count:   c.processed,
+		Sum:     c.sum.Seconds(),
+	}
+
+	var total int64
+	for i, v := range c.buckets {
+		total += v
+		b := Bucket{Count: total}
+
+		if i < len(latencyBuckets) {
+			b.LE = latencyBuckets[i].Seconds()
+		}
+
+		stats.Latency.Buckets = append(stats.Latency.Buckets, b)
+	}
+}
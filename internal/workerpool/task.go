@@ -1,20 +1,36 @@
 package workerpool
 
 import (
-	"fmt"
+	"context"
+	"time"
 )
 
 type Task struct {
-	Err  error
-	Data interface{}
-	f    func(interface{}) error
+	Err      error
+	Type     string
+	Key      string
+	Data     interface{}
+	Timeout  time.Duration
+	Attempts int
+	f        func(context.Context, interface{}) error
 }
 
-func NewTask(f func(interface{}) error, data interface{}) *Task {
-	return &Task{f: f, Data: data}
+// NewTask returns a new task, a zero timeout means the task has no deadline.
+// The task type selects the retry policy of the pool,
+// tasks with the same non-empty Key are not queued twice.
+func NewTask(taskType string, f func(context.Context, interface{}) error,
+	data interface{}, timeout time.Duration,
+) *Task {
+	return &Task{Type: taskType, f: f, Data: data, Timeout: timeout}
 }
 
-func process(workerID int, task *Task) {
-	fmt.Printf("Worker %d processes task %v\n", workerID, task.Data)
-	task.Err = task.f(task.Data)
+func process(ctx context.Context, task *Task) {
+	if task.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, task.Timeout)
+		defer cancel()
+	}
+
+	task.Attempts++
+	task.Err = task.f(ctx, task.Data)
 }
@@ -1,8 +1,10 @@
 package workerpool
 
 import (
-	"fmt"
+	"context"
 	"sync"
+
+	"go.uber.org/zap"
 )
 
 // Worker controls all work.
@@ -10,38 +12,45 @@ type Worker struct {
 	ID       int
 	taskChan chan *Task
 	quit     chan bool
+	run      func(context.Context, int, *Task)
+	log      Log
 }
 
-// NewWorker returns a new worker instance.
-func NewWorker(channel chan *Task, ID int) *Worker {
+// NewWorker returns a new worker instance,
+// run is called to process every task.
+func NewWorker(channel chan *Task, ID int, run func(context.Context, int, *Task), log Log) *Worker {
 	return &Worker{
 		ID:       ID,
 		taskChan: channel,
-		quit:     make(chan bool),
+		quit:     make(chan bool, 1),
+		run:      run,
+		log:      log,
 	}
 }
 
 // starts a worker.
-func (wr *Worker) Start(wg *sync.WaitGroup) {
-	fmt.Printf("Starting worker %d\n", wr.ID)
+func (wr *Worker) Start(ctx context.Context, wg *sync.WaitGroup) {
+	wr.log.Info("starting worker: ", zap.Int("worker", wr.ID))
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		for task := range wr.taskChan {
-			process(wr.ID, task)
+			wr.run(ctx, wr.ID, task)
 		}
 	}()
 }
 
 // StartBackground starts a worker in the background.
-func (wr *Worker) StartBackground() {
-	fmt.Printf("Starting worker %d\n", wr.ID)
+func (wr *Worker) StartBackground(ctx context.Context) {
+	wr.log.Info("starting worker: ", zap.Int("worker", wr.ID))
 
 	for {
 		select {
 		case task := <-wr.taskChan:
-			process(wr.ID, task)
+			wr.run(ctx, wr.ID, task)
+		case <-ctx.Done():
+			return
 		case <-wr.quit:
 			return
 		}
@@ -50,7 +59,7 @@ func (wr *Worker) StartBackground() {
 
 // Stop quits for worker.
 func (wr *Worker) Stop() {
-	fmt.Printf("Closing worker %d\n", wr.ID)
+	wr.log.Info("closing worker: ", zap.Int("worker", wr.ID))
 	go func() {
 		wr.quit <- true
 	}()
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_orders_next_poll_at;
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS next_poll_at,
+    DROP COLUMN IF EXISTS attempts;
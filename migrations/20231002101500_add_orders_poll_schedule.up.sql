@@ -0,0 +1,5 @@
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS next_poll_at timestamp with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS attempts integer NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS idx_orders_next_poll_at ON orders (next_poll_at)
+    WHERE status <> 'INVALID' AND status <> 'PROCESSED';
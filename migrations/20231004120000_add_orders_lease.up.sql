@@ -0,0 +1,3 @@
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS lease_owner VARCHAR(50),
+    ADD COLUMN IF NOT EXISTS lease_until timestamp with time zone;
@@ -0,0 +1,2 @@
+ALTER TABLE savings_account
+    ALTER COLUMN accrual TYPE numeric(18, 2);
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS withdrawals (
+    user_id VARCHAR(50) NOT NULL,
+    number VARCHAR(50) NOT NULL,
+    idempotency_key VARCHAR(255),
+    sum numeric(18, 2) NOT NULL,
+    processed_at timestamp without time zone NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+    );
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_withdrawals_order ON withdrawals (user_id, number);
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_withdrawals_key ON withdrawals (user_id, idempotency_key);
+INSERT INTO withdrawals (user_id, number, sum, processed_at)
+SELECT
+    user_id,
+    id_order_out,
+    - SUM(accrual),
+    MAX(processed_at)
+FROM
+    savings_account
+WHERE
+    id_order_out IS NOT NULL
+GROUP BY
+    user_id,
+    id_order_out
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,19 @@
+CREATE TABLE savings_account_restored AS
+SELECT
+    user_id,
+    processed_at,
+    id_order_in,
+    id_order_out,
+    accrual
+FROM
+    savings_account;
+DROP VIEW IF EXISTS savings_account;
+ALTER TABLE savings_account_restored RENAME TO savings_account;
+ALTER TABLE savings_account
+    ALTER COLUMN user_id SET NOT NULL,
+    ALTER COLUMN processed_at SET NOT NULL,
+    ADD FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE;
+DROP TABLE IF EXISTS postings;
+DROP TABLE IF EXISTS journal_entries;
+DROP FUNCTION IF EXISTS ledger_immutable();
+DROP FUNCTION IF EXISTS ledger_balanced();
@@ -0,0 +1,135 @@
+CREATE TABLE IF NOT EXISTS journal_entries (
+    entry_id VARCHAR(50) PRIMARY KEY,
+    kind VARCHAR(20) NOT NULL,
+    reference VARCHAR(50),
+    created_at timestamp without time zone NOT NULL
+    );
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_journal_entries_accrual ON journal_entries (reference)
+    WHERE kind = 'accrual';
+CREATE TABLE IF NOT EXISTS postings (
+    entry_id VARCHAR(50) NOT NULL,
+    account VARCHAR(50) NOT NULL,
+    id_order_in VARCHAR(50),
+    amount numeric(18, 2) NOT NULL,
+    FOREIGN KEY (entry_id) REFERENCES journal_entries (entry_id)
+    );
+CREATE INDEX IF NOT EXISTS idx_postings_account ON postings (account);
+CREATE INDEX IF NOT EXISTS idx_postings_entry ON postings (entry_id);
+
+-- move the savings account to the ledger: every accrual and every withdrawal
+-- becomes an entry balanced by the accrual source or the redemption sink account
+INSERT INTO journal_entries (entry_id, kind, reference, created_at)
+SELECT
+    md5('accrual' || user_id || id_order_in),
+    'accrual',
+    id_order_in,
+    MIN(processed_at)
+FROM
+    savings_account
+WHERE
+    id_order_out IS NULL
+GROUP BY
+    user_id,
+    id_order_in;
+INSERT INTO postings (entry_id, account, id_order_in, amount)
+SELECT
+    md5('accrual' || user_id || id_order_in),
+    user_id,
+    id_order_in,
+    SUM(accrual)
+FROM
+    savings_account
+WHERE
+    id_order_out IS NULL
+GROUP BY
+    user_id,
+    id_order_in
+UNION ALL
+SELECT
+    md5('accrual' || user_id || id_order_in),
+    'accrual_source',
+    NULL,
+    - SUM(accrual)
+FROM
+    savings_account
+WHERE
+    id_order_out IS NULL
+GROUP BY
+    user_id,
+    id_order_in;
+INSERT INTO journal_entries (entry_id, kind, reference, created_at)
+SELECT
+    md5('withdrawal' || user_id || id_order_out),
+    'withdrawal',
+    id_order_out,
+    MIN(processed_at)
+FROM
+    savings_account
+WHERE
+    id_order_out IS NOT NULL
+GROUP BY
+    user_id,
+    id_order_out;
+INSERT INTO postings (entry_id, account, id_order_in, amount)
+SELECT
+    md5('withdrawal' || user_id || id_order_out),
+    user_id,
+    id_order_in,
+    accrual
+FROM
+    savings_account
+WHERE
+    id_order_out IS NOT NULL
+UNION ALL
+SELECT
+    md5('withdrawal' || user_id || id_order_out),
+    'redemption_sink',
+    NULL,
+    - SUM(accrual)
+FROM
+    savings_account
+WHERE
+    id_order_out IS NOT NULL
+GROUP BY
+    user_id,
+    id_order_out;
+
+-- entries are immutable, corrections are made by new entries
+CREATE OR REPLACE FUNCTION ledger_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'ledger entries are immutable';
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER journal_entries_immutable BEFORE UPDATE OR DELETE ON journal_entries
+    FOR EACH ROW EXECUTE FUNCTION ledger_immutable();
+CREATE TRIGGER postings_immutable BEFORE UPDATE OR DELETE ON postings
+    FOR EACH ROW EXECUTE FUNCTION ledger_immutable();
+
+-- postings of an entry must sum up to zero by the end of the transaction
+CREATE OR REPLACE FUNCTION ledger_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT SUM(amount) FROM postings WHERE entry_id = NEW.entry_id) <> 0 THEN
+        RAISE EXCEPTION 'journal entry % is not balanced', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+CREATE CONSTRAINT TRIGGER postings_balanced AFTER INSERT ON postings
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION ledger_balanced();
+
+-- the savings account stays as a view of the user postings
+DROP TABLE savings_account;
+CREATE VIEW savings_account AS
+SELECT
+    p.account AS user_id,
+    e.created_at AS processed_at,
+    p.id_order_in,
+    CASE WHEN e.kind = 'withdrawal' THEN e.reference END AS id_order_out,
+    p.amount AS accrual
+FROM
+    postings AS p
+    INNER JOIN journal_entries AS e ON e.entry_id = p.entry_id
+WHERE
+    p.account <> 'accrual_source'
+    AND p.account <> 'redemption_sink';
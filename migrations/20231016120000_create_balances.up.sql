@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS balances (
+    user_id VARCHAR(50) PRIMARY KEY,
+    current numeric(18, 2) NOT NULL DEFAULT 0,
+    withdrawn numeric(18, 2) NOT NULL DEFAULT 0,
+    version bigint NOT NULL DEFAULT 0,
+    updated_at timestamp without time zone NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );
+
+-- fill the balances from the postings of the user accounts
+INSERT INTO balances (user_id, current, withdrawn)
+SELECT
+    p.account,
+    SUM(p.amount),
+    COALESCE(- SUM(p.amount) FILTER (WHERE e.kind = 'withdrawal'), 0)
+FROM
+    postings AS p
+    INNER JOIN journal_entries AS e ON e.entry_id = p.entry_id
+WHERE
+    p.account NOT IN ('accrual_source', 'redemption_sink')
+GROUP BY
+    p.account
+ON CONFLICT (user_id)
+    DO NOTHING;
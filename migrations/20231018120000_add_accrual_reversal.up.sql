@@ -0,0 +1,22 @@
+ALTER TABLE balances
+    ADD COLUMN IF NOT EXISTS debt numeric(18, 2) NOT NULL DEFAULT 0;
+
+-- an accrual is reversed only once
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_journal_entries_reversal ON journal_entries (reference)
+    WHERE kind = 'reversal';
+
+-- the points clawed back beyond the user balance are owed to the accrual debt account
+CREATE OR REPLACE VIEW savings_account AS
+SELECT
+    p.account AS user_id,
+    e.created_at AS processed_at,
+    p.id_order_in,
+    CASE WHEN e.kind = 'withdrawal' THEN e.reference END AS id_order_out,
+    p.amount AS accrual
+FROM
+    postings AS p
+    INNER JOIN journal_entries AS e ON e.entry_id = p.entry_id
+WHERE
+    p.account <> 'accrual_source'
+    AND p.account <> 'redemption_sink'
+    AND p.account <> 'accrual_debt';
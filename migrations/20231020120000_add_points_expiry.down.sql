@@ -0,0 +1,16 @@
+CREATE OR REPLACE VIEW savings_account AS
+SELECT
+    p.account AS user_id,
+    e.created_at AS processed_at,
+    p.id_order_in,
+    CASE WHEN e.kind = 'withdrawal' THEN e.reference END AS id_order_out,
+    p.amount AS accrual
+FROM
+    postings AS p
+    INNER JOIN journal_entries AS e ON e.entry_id = p.entry_id
+WHERE
+    p.account <> 'accrual_source'
+    AND p.account <> 'redemption_sink'
+    AND p.account <> 'accrual_debt';
+
+DROP INDEX IF EXISTS idx_postings_lot;
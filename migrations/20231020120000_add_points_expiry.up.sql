@@ -0,0 +1,18 @@
+CREATE INDEX IF NOT EXISTS idx_postings_lot ON postings (id_order_in);
+
+-- the expired points are written off to the expired points account
+CREATE OR REPLACE VIEW savings_account AS
+SELECT
+    p.account AS user_id,
+    e.created_at AS processed_at,
+    p.id_order_in,
+    CASE WHEN e.kind = 'withdrawal' THEN e.reference END AS id_order_out,
+    p.amount AS accrual
+FROM
+    postings AS p
+    INNER JOIN journal_entries AS e ON e.entry_id = p.entry_id
+WHERE
+    p.account <> 'accrual_source'
+    AND p.account <> 'redemption_sink'
+    AND p.account <> 'accrual_debt'
+    AND p.account <> 'expired_points';
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS holds;
+
+ALTER TABLE balances
+    DROP COLUMN IF EXISTS held;
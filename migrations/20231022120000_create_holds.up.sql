@@ -0,0 +1,18 @@
+ALTER TABLE balances
+    ADD COLUMN IF NOT EXISTS held numeric(18, 2) NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS holds (
+    hold_id VARCHAR(50) PRIMARY KEY,
+    user_id VARCHAR(50) NOT NULL,
+    number VARCHAR(50) NOT NULL,
+    sum numeric(18, 2) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    expires_at timestamp without time zone NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+    );
+-- an order can be held only once at a time
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_holds_order ON holds (user_id, number)
+    WHERE status = 'HELD';
+CREATE INDEX IF NOT EXISTS idx_holds_expires_at ON holds (expires_at)
+    WHERE status = 'HELD';
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_orders_user_date ON orders (user_id, date DESC, number DESC);
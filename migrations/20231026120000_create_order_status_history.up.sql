@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS order_status_history (
+    number VARCHAR(50) NOT NULL,
+    status statuses NOT NULL,
+    changed_at timestamp without time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (number) REFERENCES orders (number) ON DELETE CASCADE
+    );
+CREATE INDEX IF NOT EXISTS idx_order_status_history_number ON order_status_history (number, changed_at);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS transfers (
+    user_id VARCHAR(50) NOT NULL,
+    idempotency_key VARCHAR(255) NOT NULL,
+    recipient_id VARCHAR(50) NOT NULL,
+    sum numeric(18, 2) NOT NULL,
+    processed_at timestamp without time zone NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
+    FOREIGN KEY (recipient_id) REFERENCES users (user_id) ON DELETE CASCADE
+    );
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_transfers_key ON transfers (user_id, idempotency_key);